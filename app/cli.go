@@ -0,0 +1,113 @@
+package app
+
+import (
+	"fmt"
+	"io/ioutil"
+	nethttp "net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/github/my-manager/config"
+	"github.com/github/my-manager/raft"
+
+	"github.com/openark/golib/log"
+)
+
+// cliTimeout bounds the time a CLI command waits for the node, which may wait on raft consensus
+const cliTimeout = 60 * time.Second
+
+// cliCommand maps a CLI command onto the API path of a running node
+type cliCommand struct {
+	argument string // Name of the single required argument, if any
+	path     string
+}
+
+var cliCommands = map[string]cliCommand{
+	"raft-status":     {path: "raft-status"},
+	"raft-peers":      {path: "raft-peers"},
+	"raft-join":       {argument: "raft-address", path: "raft-join"},
+	"raft-leave":      {argument: "raft-address", path: "raft-leave"},
+	"raft-yield":      {argument: "raft-address", path: "raft-yield"},
+	"raft-yield-hint": {argument: "hostname-hint", path: "raft-yield-hint"},
+}
+
+// IsCliCommand tells whether given command is run by Cli
+func IsCliCommand(command string) bool {
+	_, ok := cliCommands[command]
+	return ok || command == "help"
+}
+
+// Cli runs a single command by calling the API of a running node, which forwards it to the
+// raft leader when needed, and prints the response. node is the base URI of that node,
+// defaulting to the URI this host advertises. user is who to identify as with proxy
+// authentication, defaulting to RaftNodeAuthUser.
+func Cli(command string, node string, user string, arguments []string) {
+	if command == "help" {
+		fmt.Print(AppPrompt)
+		return
+	}
+	spec, ok := cliCommands[command]
+	if !ok {
+		log.Fatalf("Unknown command: %s. Run `my-manager help` for usage", command)
+	}
+	path := spec.path
+	if spec.argument != "" {
+		if len(arguments) != 1 || strings.TrimSpace(arguments[0]) == "" {
+			log.Fatalf("Usage: my-manager %s <%s>", command, spec.argument)
+		}
+		path = fmt.Sprintf("%s/%s", path, url.PathEscape(strings.TrimSpace(arguments[0])))
+	}
+	body, err := cliRequest(node, user, path)
+	if body != nil {
+		fmt.Println(strings.TrimSpace(string(body)))
+	}
+	if err != nil {
+		log.Fatale(err)
+	}
+}
+
+// cliRequest issues an API GET request to given node, authenticating as nodes do with each
+// other unless a proxy authentication user is given
+func cliRequest(node string, user string, path string) ([]byte, error) {
+	if node == "" {
+		var err error
+		if node, err = oraft.ThisNodeURI(); err != nil {
+			return nil, err
+		}
+	}
+	apiURL := fmt.Sprintf("%s%s/api/%s", strings.TrimRight(node, "/"), config.Config().URLPrefix, path)
+
+	if err := oraft.SetupHttpClient(); err != nil {
+		return nil, err
+	}
+	client := &nethttp.Client{Transport: oraft.NewHttpTransport(), Timeout: cliTimeout}
+	req, err := nethttp.NewRequest("GET", apiURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(config.Config().AuthenticationMethod) {
+	case "basic", "multi":
+		req.SetBasicAuth(config.Config().HTTPAuthUser, config.Config().HTTPAuthPassword)
+	case "proxy":
+		if user == "" {
+			// Honored by the node when this host is a raft peer
+			user = config.Config().RaftNodeAuthUser
+		}
+		req.Header.Set(config.Config().AuthUserHeader, user)
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != nethttp.StatusOK {
+		return body, fmt.Errorf("%s: got %d status", apiURL, res.StatusCode)
+	}
+	return body, nil
+}
@@ -1,13 +1,39 @@
 package app
 
 const AppPrompt string = `
-my-manager [-config ]  [--verbose|--debug] | http
+my-manager [-config ]  [--verbose|--debug] [-node ] [-user ] | http | <command> [argument]
 
 Cheatsheet:
     Run my-manager in HTTP mode:
 
         my-manager --debug http
 
+    Show the raft state of this host's node (or of the node given with -node): term, log
+    indexes, snapshot, peers and, on the leader, each member's last contact:
+
+        my-manager raft-status
+
+    List raft peers, as known to this host's node:
+
+        my-manager raft-peers
+
+    Add a node to the raft cluster, or remove one. The request is forwarded to the leader,
+    and the new membership is persisted by all nodes:
+
+        my-manager raft-join 10.0.0.4:10008
+        my-manager raft-leave 10.0.0.3:10008
+
+    Hand raft leadership over, before maintenance on the leader's host, to a given peer or to
+    a host whose name contains a hint. Waits until the new leader is confirmed:
+
+        my-manager raft-yield 10.0.0.2:10008
+        my-manager raft-yield-hint db-manager-02
+
+    With proxy authentication, commands identify as RaftNodeAuthUser, which the node honors
+    from raft peers only; elsewhere, give a power user with -user:
+
+        my-manager -node https://db-manager-01:3000 -user alice raft-yield-hint db-manager-02
+
     See all possible commands:
 
         my-manager help
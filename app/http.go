@@ -30,8 +30,8 @@ func Http() {
 // Iterate over the private keys and get passwords for them
 // Don't prompt for a password a second time if the files are the same
 func promptForSSLPasswords() {
-	if ssl.IsEncryptedPEM(config.Config.SSLPrivateKeyFile) {
-		sslPEMPassword = ssl.GetPEMPassword(config.Config.SSLPrivateKeyFile)
+	if ssl.IsEncryptedPEM(config.Config().SSLPrivateKeyFile) {
+		sslPEMPassword = ssl.GetPEMPassword(config.Config().SSLPrivateKeyFile)
 	}
 }
 
@@ -39,18 +39,18 @@ func promptForSSLPasswords() {
 func standardHttp() {
 	m := martini.Classic()
 
-	switch strings.ToLower(config.Config.AuthenticationMethod) {
+	switch strings.ToLower(config.Config().AuthenticationMethod) {
 	case "basic":
 		{
-			if config.Config.HTTPAuthUser == "" {
+			if config.Config().HTTPAuthUser == "" {
 				// Still allowed; may be disallowed in future versions
 				log.Warning("AuthenticationMethod is configured as 'basic' but HTTPAuthUser undefined. Running without authentication.")
 			}
-			m.Use(auth.Basic(config.Config.HTTPAuthUser, config.Config.HTTPAuthPassword))
+			m.Use(auth.Basic(config.Config().HTTPAuthUser, config.Config().HTTPAuthPassword))
 		}
 	case "multi":
 		{
-			if config.Config.HTTPAuthUser == "" {
+			if config.Config().HTTPAuthUser == "" {
 				// Still allowed; may be disallowed in future versions
 				log.Fatal("AuthenticationMethod is configured as 'multi' but HTTPAuthUser undefined")
 			}
@@ -60,7 +60,7 @@ func standardHttp() {
 					// Will be treated as "read-only"
 					return true
 				}
-				return auth.SecureCompare(username, config.Config.HTTPAuthUser) && auth.SecureCompare(password, config.Config.HTTPAuthPassword)
+				return auth.SecureCompare(username, config.Config().HTTPAuthUser) && auth.SecureCompare(password, config.Config().HTTPAuthPassword)
 			}))
 		}
 	default:
@@ -70,41 +70,43 @@ func standardHttp() {
 		}
 	}
 
+	m.Use(http.UncompressedStreams)
 	m.Use(gzip.All())
+	m.Use(http.ServedBy)
 	// Render html templates from templates directory
 	m.Use(render.Renderer(render.Options{
 		Directory:       "resources",
 		Layout:          "templates/layout",
 		HTMLContentType: "text/html",
 	}))
-	m.Use(martini.Static("resources/public", martini.StaticOptions{Prefix: config.Config.URLPrefix}))
-	if config.Config.UseMutualTLS {
-		m.Use(ssl.VerifyOUs(config.Config.SSLValidOUs))
+	m.Use(martini.Static("resources/public", martini.StaticOptions{Prefix: config.Config().URLPrefix}))
+	if config.Config().UseMutualTLS {
+		m.Use(ssl.VerifyOUs(config.Config().SSLValidOUs))
 	}
 	//inst.SetMaintenanceOwner(process.ThisHostname)
 	log.Info("Starting continuous operation")
 	go logic.ContinuousOperation()
 
 	log.Info("Registering endpoints")
-	http.API.URLPrefix = config.Config.URLPrefix
+	http.API.URLPrefix = config.Config().URLPrefix
 	http.API.RegisterRequests(m)
 
-	if config.Config.UseSSL {
+	if config.Config().UseSSL {
 		log.Info("Starting HTTPS listener")
-		tlsConfig, err := ssl.NewTLSConfig(config.Config.SSLCAFile, config.Config.UseMutualTLS)
+		tlsConfig, err := ssl.NewTLSConfig(config.Config().SSLCAFile, config.Config().UseMutualTLS)
 		if err != nil {
 			log.Fatale(err)
 		}
-		tlsConfig.InsecureSkipVerify = config.Config.SSLSkipVerify
-		if err = ssl.AppendKeyPairWithPassword(tlsConfig, config.Config.SSLCertFile, config.Config.SSLPrivateKeyFile, sslPEMPassword); err != nil {
+		tlsConfig.InsecureSkipVerify = config.Config().SSLSkipVerify
+		if err = ssl.AppendKeyPairWithPassword(tlsConfig, config.Config().SSLCertFile, config.Config().SSLPrivateKeyFile, sslPEMPassword); err != nil {
 			log.Fatale(err)
 		}
-		if err = ssl.ListenAndServeTLS(config.Config.ListenAddress, m, tlsConfig); err != nil {
+		if err = ssl.ListenAndServeTLS(config.Config().ListenAddress, m, tlsConfig); err != nil {
 			log.Fatale(err)
 		}
 	} else {
-		log.Infof("Starting HTTP listener on %+v", config.Config.ListenAddress)
-		if err := nethttp.ListenAndServe(config.Config.ListenAddress, m); err != nil {
+		log.Infof("Starting HTTP listener on %+v", config.Config().ListenAddress)
+		if err := nethttp.ListenAndServe(config.Config().ListenAddress, m); err != nil {
 			log.Fatale(err)
 		}
 	}
@@ -5,7 +5,13 @@ import (
 	"fmt"
 	"github.com/openark/golib/log"
 	"os"
+	"reflect"
+	"regexp"
 	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/github/my-manager/util"
 )
 
 const (
@@ -18,6 +24,19 @@ const (
 
 var configurationLoaded chan bool = make(chan bool)
 
+// staticSettings cannot change at runtime: they are bound to listeners, raft setup, backend
+// connections or registered routes. Reload refuses configuration changing any of them.
+var staticSettings = []string{
+	"ListenAddress", "HTTPAdvertise", "URLPrefix", "ApiEndpoint", "StatusEndpoint",
+	"RaftEnabled", "RaftBind", "RaftAdvertise", "RaftDataDir", "DefaultRaftPort", "RaftNodes",
+	"RaftNodesStatusCheckIntervalSeconds", "DomainCheckIntervalSeconds",
+	"AuthenticationMethod", "HTTPAuthUser", "HTTPAuthPassword",
+	"UseSSL", "UseMutualTLS", "SSLSkipVerify", "SSLPrivateKeyFile", "SSLCertFile", "SSLCAFile", "SSLValidOUs",
+	"MySQLReadTimeoutSeconds", "MySQLConnectTimeoutSeconds", "MySQLRejectReadOnly", "MySQLMaxPoolConnections", "MySQLConnectionLifetimeSeconds",
+	"ConnBackendDbFlag", "BackendDbHosts", "BackendDbPort", "BackendDbUser", "BackendDbPass", "BackendDb",
+}
+var reloadMutex sync.Mutex
+
 func NewAppVersion() string {
 	AppVersion := "1.0.2"
 	return AppVersion
@@ -27,18 +46,18 @@ func NewAppVersion() string {
 // Some of the parameteres have reasonable default values, and some (like database credentials) are
 // strictly expected from user.
 type Configuration struct {
-	Debug                                    bool   // set debug mode (similar to --debug option)
-	ListenAddress                            string // Where this system HTTP should listen for TCP
-	RaftEnabled                              bool   // When true, setup this system in a raft consensus layout. When false (default) all Raft* variables are ignored
-	RaftBind                                 string
-	RaftDataDir                              string
-	RaftAdvertise                            string
-	DefaultRaftPort                          int      // if a RaftNodes entry does not specify port, use this one
-	RaftNodes                                []string // Raft nodes to make initial connection with
-	RaftNodesStatusCheckIntervalSeconds      uint
-	RaftNodesStatusAlertProcess              string
-	RaftLeaderDomain                         string
-	DomainCheckIntervalSeconds               uint
+	Debug                               bool   // set debug mode (similar to --debug option)
+	ListenAddress                       string // Where this system HTTP should listen for TCP
+	RaftEnabled                         bool   // When true, setup this system in a raft consensus layout. When false (default) all Raft* variables are ignored
+	RaftBind                            string
+	RaftDataDir                         string
+	RaftAdvertise                       string
+	DefaultRaftPort                     int      // if a RaftNodes entry does not specify port, use this one
+	RaftNodes                           []string // Raft nodes to make initial connection with. Once membership changed via raft-join/raft-leave, the peers persisted in RaftDataDir apply instead
+	RaftNodesStatusCheckIntervalSeconds uint
+	RaftNodesStatusAlertProcess         string
+	RaftLeaderDomain                    string
+	DomainCheckIntervalSeconds          uint
 	//SwithDomainProcess                     string
 	SwithDomainProcess                       []string
 	HTTPAdvertise                            string   // optional, for raft setups, what is the HTTP address this node will advertise to its peers (potentially use where behind NAT or when rerouting ports; example: "http://11.22.33.44:3030")
@@ -51,6 +70,7 @@ type Configuration struct {
 	HTTPAuthPassword                         string   // Password for HTTP Basic authentication
 	AuthUserHeader                           string   // HTTP header indicating auth user, when AuthenticationMethod is "proxy"
 	PowerAuthUsers                           []string // On AuthenticationMethod == "proxy", list of users that can make changes. All others are read-only.
+	RaftNodeAuthUser                         string   // On AuthenticationMethod == "proxy", user which raft nodes identify as when calling the leader. Only honored on requests from raft peers.
 	ServeAgentsHttp                          bool     // Spawn another HTTP interface dedicated for orchestrator-agent
 	AgentsUseSSL                             bool     // When "true" this system will listen on agents port with SSL as well as connect to agents via SSL
 	UseSSL                                   bool     // Use SSL on the server web port
@@ -78,13 +98,41 @@ type Configuration struct {
 	BackendDbPass     string
 	BackendDb         string
 
-	Processes []map[string]string
+	Processes             []*Process
+	ProcessJobExpireHours uint // Number of hours after which async process job records are purged
+	ProcessTimeoutSeconds uint // Default execution timeout for Processes which do not specify "timeoutSeconds". 0 means no timeout
+	AuditPageSize         int  // Number of entries returned per page by the audit API
+	AuditExpireHours      uint // Number of hours after which process execution audit entries are purged
+	MaxBatchInvocations   int  // Maximum number of invocations in a single batch request
+	MaxBatchConcurrency   int  // Maximum concurrency a parallel batch may request
+
+	WebhookSecret         string // Key with which job callbacks are signed (HMAC-SHA256). Callbacks are refused while empty
+	WebhookTimeoutSeconds uint   // Time to wait for a callback URL to respond
+	WebhookMaxAttempts    uint   // Deliveries of a job callback attempted before giving up
+
+	CgroupParent string // cgroup v2 directory under which processes with resource limits get a cgroup per run. Empty for rlimits only
+
+	RedactionPatterns []string // Regular expressions whose matches are masked in command logs, errors, audit and returned output
+
+	compiledRedactionPatterns []*regexp.Regexp
 }
 
-// Config is *the* configuration instance, used globally to get configuration data
-var Config = newConfiguration()
+// currentConfig holds *the* configuration instance. Reload swaps it atomically; each value
+// stored is never modified afterwards.
+var currentConfig atomic.Value
 var readFileNames []string
 
+func init() {
+	currentConfig.Store(newConfiguration())
+}
+
+// Config returns *the* configuration instance, used globally to get configuration data.
+// Callers reading several related settings should keep the returned instance rather than
+// call Config again, so that they observe a single configuration across a reload.
+func Config() *Configuration {
+	return currentConfig.Load().(*Configuration)
+}
+
 func newConfiguration() *Configuration {
 	return &Configuration{
 		Debug:                                    false,
@@ -109,6 +157,7 @@ func newConfiguration() *Configuration {
 		HTTPAuthPassword:                         "",
 		AuthUserHeader:                           "X-Forwarded-User",
 		PowerAuthUsers:                           []string{"*"},
+		RaftNodeAuthUser:                         "my-manager-node",
 		UseSSL:                                   false,
 		UseMutualTLS:                             false,
 		SSLValidOUs:                              []string{},
@@ -122,7 +171,17 @@ func newConfiguration() *Configuration {
 		MySQLRejectReadOnly:                      false,
 		MySQLMaxPoolConnections:                  128, // limit concurrent conns to backend DB
 		MySQLConnectionLifetimeSeconds:           0,
-		Processes:                                []map[string]string{},
+		Processes:                                []*Process{},
+		ProcessJobExpireHours:                    24 * 7,
+		AuditPageSize:                            20,
+		AuditExpireHours:                         24 * 90,
+		MaxBatchInvocations:                      100,
+		MaxBatchConcurrency:                      16,
+		WebhookSecret:                            "",
+		WebhookTimeoutSeconds:                    10,
+		WebhookMaxAttempts:                       5,
+		CgroupParent:                             "",
+		RedactionPatterns:                        []string{},
 		ConnBackendDbFlag:                        false,
 	}
 }
@@ -143,6 +202,33 @@ func (this *Configuration) postReadAdjustments() error {
 	if this.RaftAdvertise == "" {
 		this.RaftAdvertise = this.RaftBind
 	}
+	if this.MaxBatchInvocations <= 0 {
+		return fmt.Errorf("MaxBatchInvocations must be positive")
+	}
+	if this.MaxBatchConcurrency <= 0 {
+		return fmt.Errorf("MaxBatchConcurrency must be positive")
+	}
+	this.compiledRedactionPatterns = []*regexp.Regexp{}
+	for _, pattern := range this.RedactionPatterns {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("RedactionPatterns: invalid pattern %s: %+v", pattern, err)
+		}
+		this.compiledRedactionPatterns = append(this.compiledRedactionPatterns, compiled)
+	}
+	processKeys := make(map[string]bool)
+	for _, process := range this.Processes {
+		if err := process.postReadAdjustments(); err != nil {
+			return err
+		}
+		if err := process.ValidateCgroupLimits(this.CgroupParent); err != nil {
+			return err
+		}
+		if processKeys[process.Key] {
+			return fmt.Errorf("Processes: duplicate key %s", process.Key)
+		}
+		processKeys[process.Key] = true
+	}
 	return nil
 
 }
@@ -154,7 +240,7 @@ func ForceRead(fileName string) *Configuration {
 		log.Fatal("Cannot read config file:", fileName, err)
 	}
 	readFileNames = []string{fileName}
-	return Config
+	return Config()
 }
 
 // Read reads configuration from zero, either, some or all given files, in order of input.
@@ -164,30 +250,77 @@ func Read(fileNames ...string) *Configuration {
 		read(fileName)
 	}
 	readFileNames = fileNames
-	return Config
+	return Config()
+}
+
+// Reload re-reads the configuration files originally read, validates them and swaps in
+// the new configuration. The running configuration is kept if any file fails to parse or
+// validate, or if any static setting changed.
+func Reload() error {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+
+	reloaded := newConfiguration()
+	filesRead := 0
+	for _, fileName := range readFileNames {
+		file, err := os.Open(fileName)
+		if err != nil {
+			if os.IsNotExist(err) && len(readFileNames) > 1 {
+				// Read() silently skips missing files, and so do we
+				continue
+			}
+			return log.Errorf("Cannot reload config file %s: %+v", fileName, err)
+		}
+		err = json.NewDecoder(file).Decode(reloaded)
+		file.Close()
+		if err != nil {
+			return log.Errorf("Cannot reload config file %s: %+v", fileName, err)
+		}
+		filesRead++
+	}
+	if filesRead == 0 {
+		return log.Errorf("Cannot reload configuration: none of %s could be read", strings.Join(readFileNames, ", "))
+	}
+	if err := reloaded.postReadAdjustments(); err != nil {
+		return log.Errorf("Cannot reload configuration: %+v", err)
+	}
+	current := reflect.ValueOf(Config()).Elem()
+	candidate := reflect.ValueOf(reloaded).Elem()
+	for _, setting := range staticSettings {
+		if !reflect.DeepEqual(current.FieldByName(setting).Interface(), candidate.FieldByName(setting).Interface()) {
+			return log.Errorf("Cannot reload configuration: %s cannot change at runtime; restart is required", setting)
+		}
+	}
+	currentConfig.Store(reloaded)
+	util.SetRedactionPatterns(reloaded.compiledRedactionPatterns)
+	log.Infof("Reloaded config: %s", strings.Join(readFileNames, ", "))
+	return nil
 }
 
 // read reads configuration from given file, or silently skips if the file does not exist.
 // If the file does exist, then it is expected to be in valid JSON format or the function bails out.
+// It updates the configuration in place, and is only used at startup, before it is in use.
 func read(fileName string) (*Configuration, error) {
+	loaded := Config()
 	if fileName == "" {
-		return Config, fmt.Errorf("Empty file name")
+		return loaded, fmt.Errorf("Empty file name")
 	}
 	file, err := os.Open(fileName)
 	if err != nil {
-		return Config, err
+		return loaded, err
 	}
 	decoder := json.NewDecoder(file)
-	err = decoder.Decode(Config)
+	err = decoder.Decode(loaded)
 	if err == nil {
 		log.Infof("Read config: %s", fileName)
 	} else {
 		log.Fatal("Cannot read config file:", fileName, err)
 	}
-	if err := Config.postReadAdjustments(); err != nil {
+	if err := loaded.postReadAdjustments(); err != nil {
 		log.Fatale(err)
 	}
-	return Config, err
+	util.SetRedactionPatterns(loaded.compiledRedactionPatterns)
+	return loaded, err
 }
 
 // MarkConfigurationLoaded is called once configuration has first been loaded.
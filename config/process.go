@@ -0,0 +1,333 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/github/my-manager/util"
+)
+
+const (
+	ParamTypeString   = "string"
+	ParamTypeInt      = "int"
+	ParamTypeHostname = "hostname"
+	ParamTypePort     = "port"
+	ParamTypePath     = "path"
+	ParamTypeEnum     = "enum"
+)
+
+const (
+	OutputFormatText = "text"
+	OutputFormatJSON = "json"
+)
+
+const (
+	OverlapPolicySkip    = "skip"
+	OverlapPolicyQueue   = "queue"
+	OverlapPolicyReplace = "replace"
+)
+
+const (
+	InterpreterBash    = "bash"
+	InterpreterSh      = "sh"
+	InterpreterPython3 = "python3"
+	InterpreterExec    = "exec" // No interpreter: the script is a command line, run without a shell
+)
+
+var knownInterpreters = []string{InterpreterBash, InterpreterSh, InterpreterPython3, InterpreterExec}
+
+var knownParamTypes = []string{ParamTypeString, ParamTypeInt, ParamTypeHostname, ParamTypePort, ParamTypePath, ParamTypeEnum}
+
+var paramNameRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// paramEnvPrefix prefixes the environment variables through which parameter values reach scripts
+const paramEnvPrefix = "MANAGER_PARAM_"
+
+// sensitiveParamNameRegexp matches names of parameters treated as secret even when not declared so
+var sensitiveParamNameRegexp = regexp.MustCompile(`(?i)(passw|pwd|secret|token|credential|private)`)
+
+// ProcessParam declares a parameter accepted by a process. Values are validated against
+// the type and optional regex, then handed to the script via environment and positional arguments.
+type ProcessParam struct {
+	Name     string   `json:"name"`
+	Type     string   `json:"type"`     // One of string, int, hostname, port, path, enum. Defaults to string
+	Regex    string   `json:"regex"`    // Optional; the entire value must match
+	Values   []string `json:"values"`   // Allowed values when Type is enum
+	Required bool     `json:"required"` // When true, requests must provide a value
+	Secret   bool     `json:"secret"`   // When true, the value is masked in logs, audit, errors and returned output
+
+	compiledRegex *regexp.Regexp
+}
+
+// CompiledRegex returns the compiled Regex, or nil if none is defined
+func (this *ProcessParam) CompiledRegex() *regexp.Regexp {
+	return this.compiledRegex
+}
+
+// EnvName returns the name of the environment variable holding the parameter's value
+func (this *ProcessParam) EnvName() string {
+	return paramEnvPrefix + strings.ToUpper(this.Name)
+}
+
+// IsSecret returns true when the value must never be logged or returned: the parameter is
+// declared secret, or its name suggests a password, token or key
+func (this *ProcessParam) IsSecret() bool {
+	return this.Secret || sensitiveParamNameRegexp.MatchString(this.Name)
+}
+
+// Process is a script which may be invoked through ApiEndpoint by its key, and/or run periodically.
+// Field names follow the original free-form map entries so that existing config files still apply.
+type Process struct {
+	Key                     string         `json:"key"`
+	Description             string         `json:"description"`
+	Param                   string         `json:"param"`  // Legacy comma separated list of parameter names; each becomes a string param
+	Params                  []ProcessParam `json:"params"` // Typed parameter declarations
+	RunIntervalSeconds      string         `json:"runIntervalSeconds"`
+	Cron                    string         `json:"cron"`          // 5 or 6 field cron expression; an alternative to runIntervalSeconds
+	Timezone                string         `json:"timezone"`      // IANA time zone in which cron is evaluated. Defaults to local time
+	OverlapPolicy           string         `json:"overlapPolicy"` // What a scheduled activation does while the previous run is active: "skip" (default), "queue" or "replace"
+	OutputFlag              string         `json:"outputFlag"`
+	OutputFormat            string         `json:"outputFormat"` // "text" (default) or "json", in which case stdout is decoded as JSON
+	TimeoutSeconds          string         `json:"timeoutSeconds"`
+	MaxConcurrency          string         `json:"maxConcurrency"`          // Maximum simultaneous runs on this node; "1" makes runs mutually exclusive. Empty or "0" means unlimited
+	MaxQueued               string         `json:"maxQueued"`               // Maximum runs waiting for a free slot once MaxConcurrency is reached; beyond that runs are rejected
+	QueueTimeoutSeconds     string         `json:"queueTimeoutSeconds"`     // Maximum time a run waits in the queue before being rejected
+	ClusterLock             bool           `json:"clusterLock"`             // When true, at most one node in the raft group runs the process at any time
+	ClusterLockLeaseSeconds string         `json:"clusterLockLeaseSeconds"` // Lease of the cluster lock, renewed while running. Defaults to 30
+	RetryMaxAttempts        string         `json:"retryMaxAttempts"`        // Attempts made of a failing run, the first included. Empty or "1" means no retries
+	RetryBackoffSeconds     string         `json:"retryBackoffSeconds"`     // Delay before the first retry. Defaults to 1
+	RetryBackoffMultiplier  string         `json:"retryBackoffMultiplier"`  // Factor applied to the delay after each retry. Defaults to 2
+	RetryMaxBackoffSeconds  string         `json:"retryMaxBackoffSeconds"`  // Cap on the delay between retries. Defaults to 300
+	RetryJitter             string         `json:"retryJitter"`             // Fraction, between 0 and 1, by which each delay is randomly shortened or lengthened
+	RetryExitCodes          []int          `json:"retryExitCodes"`          // Exit codes which are retried; -1 stands for runs killed, e.g. on timeout. Empty means any non-zero exit code
+	CallbackURL             string         `json:"callbackUrl"`             // Default URL notified when an async job of the process finishes
+	Interpreter             string         `json:"interpreter"`             // One of bash (default), sh, python3 or exec
+	WorkingDirectory        string         `json:"workingDirectory"`        // Absolute directory the script runs in. Defaults to the daemon's
+	Env                     []string       `json:"env"`                     // "NAME=value" variables added to the script environment
+	EnvAllowList            []string       `json:"envAllowList"`            // When set, only these daemon variables are inherited; "PREFIX_*" matches by prefix
+	RunAsUid                string         `json:"runAsUid"`                // Numeric user id the script runs as; requires runAsGid and a privileged daemon
+	RunAsGid                string         `json:"runAsGid"`                // Numeric group id the script runs as; requires runAsUid
+	MemoryLimitBytes        string         `json:"memoryLimitBytes"`        // Memory the script may use: a cgroup memory.max, or else an address space rlimit
+	CpuTimeLimitSeconds     string         `json:"cpuTimeLimitSeconds"`     // CPU time after which the script is killed
+	OpenFilesLimit          string         `json:"openFilesLimit"`          // Maximum open files per process of the script
+	ProcessesLimit          string         `json:"processesLimit"`          // Maximum processes in the script's cgroup; requires CgroupParent
+	MaxOutputBytes          string         `json:"maxOutputBytes"`          // Captured output per stream, beyond which it is truncated. Defaults to 1MB
+	Script                  string         `json:"script"`
+
+	cronSchedule *util.CronSchedule
+}
+
+// postReadAdjustments normalizes legacy "param" into Params and validates declarations
+func (this *Process) postReadAdjustments() error {
+	if this.Key == "" {
+		return fmt.Errorf("Processes: found entry with empty key")
+	}
+	switch this.OutputFormat {
+	case "":
+		this.OutputFormat = OutputFormatText
+	case OutputFormatText, OutputFormatJSON:
+	default:
+		return fmt.Errorf("Processes: %s: unknown outputFormat %q", this.Key, this.OutputFormat)
+	}
+	if this.Cron != "" {
+		if this.RunIntervalSeconds != "" {
+			return fmt.Errorf("Processes: %s: only one of runIntervalSeconds and cron may be set", this.Key)
+		}
+		var err error
+		location := time.Local
+		if this.Timezone != "" {
+			if location, err = time.LoadLocation(this.Timezone); err != nil {
+				return fmt.Errorf("Processes: %s: invalid timezone %q: %+v", this.Key, this.Timezone, err)
+			}
+		}
+		if this.cronSchedule, err = util.ParseCronSchedule(this.Cron, location); err != nil {
+			return fmt.Errorf("Processes: %s: %+v", this.Key, err)
+		}
+	}
+	switch this.OverlapPolicy {
+	case "":
+		this.OverlapPolicy = OverlapPolicySkip
+	case OverlapPolicySkip, OverlapPolicyQueue, OverlapPolicyReplace:
+	default:
+		return fmt.Errorf("Processes: %s: unknown overlapPolicy %q", this.Key, this.OverlapPolicy)
+	}
+	if this.RetryBackoffMultiplier != "" {
+		if multiplier, err := strconv.ParseFloat(this.RetryBackoffMultiplier, 64); err != nil || multiplier < 1 {
+			return fmt.Errorf("Processes: %s: retryBackoffMultiplier must be a number no less than 1; got %q", this.Key, this.RetryBackoffMultiplier)
+		}
+	}
+	if this.RetryJitter != "" {
+		if jitter, err := strconv.ParseFloat(this.RetryJitter, 64); err != nil || jitter < 0 || jitter > 1 {
+			return fmt.Errorf("Processes: %s: retryJitter must be a number between 0 and 1; got %q", this.Key, this.RetryJitter)
+		}
+	}
+	switch this.Interpreter {
+	case "":
+		this.Interpreter = InterpreterBash
+	case InterpreterBash, InterpreterSh, InterpreterPython3, InterpreterExec:
+	default:
+		return fmt.Errorf("Processes: %s: unknown interpreter %q; expected one of %s", this.Key, this.Interpreter, strings.Join(knownInterpreters, ", "))
+	}
+	if this.WorkingDirectory != "" && !filepath.IsAbs(this.WorkingDirectory) {
+		return fmt.Errorf("Processes: %s: workingDirectory must be an absolute path; got %q", this.Key, this.WorkingDirectory)
+	}
+	for _, variable := range this.Env {
+		if name := strings.SplitN(variable, "=", 2)[0]; !strings.Contains(variable, "=") || !paramNameRegexp.MatchString(name) {
+			return fmt.Errorf("Processes: %s: invalid env entry %q; expected NAME=value", this.Key, variable)
+		}
+	}
+	if (this.RunAsUid == "") != (this.RunAsGid == "") {
+		return fmt.Errorf("Processes: %s: runAsUid and runAsGid must be set together", this.Key)
+	}
+	for _, id := range []string{this.RunAsUid, this.RunAsGid} {
+		if _, err := strconv.ParseUint(id, 10, 32); id != "" && err != nil {
+			return fmt.Errorf("Processes: %s: runAsUid and runAsGid must be numeric ids; got %q", this.Key, id)
+		}
+	}
+	if this.CallbackURL != "" {
+		if err := ValidateCallbackURL(this.CallbackURL); err != nil {
+			return fmt.Errorf("Processes: %s: %+v", this.Key, err)
+		}
+	}
+	for _, name := range strings.Split(this.Param, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || this.GetParam(name) != nil {
+			continue
+		}
+		this.Params = append(this.Params, ProcessParam{Name: name, Type: ParamTypeString})
+	}
+	envNames := make(map[string]string)
+	for i := range this.Params {
+		param := &this.Params[i]
+		if !paramNameRegexp.MatchString(param.Name) {
+			return fmt.Errorf("Processes: %s: invalid parameter name %q", this.Key, param.Name)
+		}
+		// Parameters reach the script as upper-cased environment variables
+		if other, found := envNames[param.EnvName()]; found {
+			return fmt.Errorf("Processes: %s: parameter %s clashes with %s; names must differ other than by case", this.Key, param.Name, other)
+		}
+		envNames[param.EnvName()] = param.Name
+		if param.Type == "" {
+			param.Type = ParamTypeString
+		}
+		if !isKnownParamType(param.Type) {
+			return fmt.Errorf("Processes: %s: parameter %s has unknown type %q; expected one of %s", this.Key, param.Name, param.Type, strings.Join(knownParamTypes, ", "))
+		}
+		if param.Type == ParamTypeEnum && len(param.Values) == 0 {
+			return fmt.Errorf("Processes: %s: enum parameter %s must list its values", this.Key, param.Name)
+		}
+		if param.Regex != "" {
+			compiled, err := regexp.Compile(fmt.Sprintf("^(?:%s)$", param.Regex))
+			if err != nil {
+				return fmt.Errorf("Processes: %s: parameter %s has invalid regex: %+v", this.Key, param.Name, err)
+			}
+			param.compiledRegex = compiled
+		}
+	}
+	return this.validatePlaceholders()
+}
+
+// validatePlaceholders rejects {name} placeholders which would not be substituted safely. Only
+// bash and sh scripts have placeholders rewritten into environment references; exec scripts
+// have them substituted as entire words. Other interpreters read parameters from the environment.
+func (this *Process) validatePlaceholders() error {
+	for i := range this.Params {
+		param := &this.Params[i]
+		placeholder := fmt.Sprintf("{%s}", param.Name)
+		switch this.Interpreter {
+		case InterpreterBash, InterpreterSh:
+		case InterpreterExec:
+			for _, word := range strings.Fields(this.Script) {
+				if strings.Contains(word, placeholder) && word != placeholder && word != "'"+placeholder+"'" && word != `"`+placeholder+`"` {
+					return fmt.Errorf("Processes: %s: placeholder %s must make up an entire word with the exec interpreter", this.Key, placeholder)
+				}
+			}
+		default:
+			if strings.Contains(this.Script, placeholder) {
+				return fmt.Errorf("Processes: %s: placeholder %s is not substituted with the %s interpreter; read the %s environment variable instead", this.Key, placeholder, this.Interpreter, param.EnvName())
+			}
+		}
+	}
+	return nil
+}
+
+// Validate normalizes and validates a process definition which did not come from a config file
+func (this *Process) Validate() error {
+	return this.postReadAdjustments()
+}
+
+// ValidateCgroupLimits rejects limits which only a cgroup enforces, when no CgroupParent is set.
+// Without a cgroup, processesLimit could only map to the process count rlimit, which counts
+// every process of the user the script runs as, not those of the script.
+func (this *Process) ValidateCgroupLimits(cgroupParent string) error {
+	if cgroupParent == "" && util.ConvStrToUInt(this.ProcessesLimit) > 0 {
+		return fmt.Errorf("Processes: %s: processesLimit requires CgroupParent to be set", this.Key)
+	}
+	return nil
+}
+
+// IsScheduled returns true when the process runs periodically, by interval or by cron expression
+func (this *Process) IsScheduled() bool {
+	return this.RunIntervalSeconds != "" || this.Cron != ""
+}
+
+// CronSchedule returns the parsed cron expression, or nil when the process has none
+func (this *Process) CronSchedule() *util.CronSchedule {
+	return this.cronSchedule
+}
+
+// IsOutputCaptured returns true when the process output is collected and returned to callers
+func (this *Process) IsOutputCaptured() bool {
+	return this.OutputFlag == "1"
+}
+
+// GetParam returns the declared parameter by given name, or nil when there is none
+func (this *Process) GetParam(name string) *ProcessParam {
+	for i := range this.Params {
+		if this.Params[i].Name == name {
+			return &this.Params[i]
+		}
+	}
+	return nil
+}
+
+// Equals returns true when both processes have the same definition
+func (this *Process) Equals(other *Process) bool {
+	thisDefinition, err := json.Marshal(this)
+	if err != nil {
+		return false
+	}
+	otherDefinition, err := json.Marshal(other)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(thisDefinition, otherDefinition)
+}
+
+// ValidateCallbackURL checks a job callback URL is an absolute http or https URL
+func ValidateCallbackURL(callbackURL string) error {
+	parsed, err := url.Parse(callbackURL)
+	if err != nil {
+		return fmt.Errorf("invalid callbackUrl: %+v", err)
+	}
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("invalid callbackUrl %q: expected an absolute http or https URL", callbackURL)
+	}
+	return nil
+}
+
+func isKnownParamType(paramType string) bool {
+	for _, known := range knownParamTypes {
+		if paramType == known {
+			return true
+		}
+	}
+	return false
+}
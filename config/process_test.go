@@ -0,0 +1,138 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestProcessParamValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		process Process
+		invalid bool
+	}{
+		{
+			name:    "typed params",
+			process: Process{Key: "p", Params: []ProcessParam{{Name: "host", Type: ParamTypeHostname, Required: true}, {Name: "port", Type: ParamTypePort}}},
+		},
+		{
+			name:    "enum with values",
+			process: Process{Key: "p", Params: []ProcessParam{{Name: "mode", Type: ParamTypeEnum, Values: []string{"fast", "safe"}}}},
+		},
+		{
+			name:    "legacy param list",
+			process: Process{Key: "p", Param: "path, depth", Script: "ls {path} {depth}"},
+		},
+		{
+			name:    "legacy param list overlapping typed params",
+			process: Process{Key: "p", Param: "port", Params: []ProcessParam{{Name: "port", Type: ParamTypePort}}},
+		},
+		{
+			name:    "empty key",
+			process: Process{Params: []ProcessParam{{Name: "host"}}},
+			invalid: true,
+		},
+		{
+			name:    "invalid name",
+			process: Process{Key: "p", Params: []ProcessParam{{Name: "host-name"}}},
+			invalid: true,
+		},
+		{
+			name:    "name starting with a digit",
+			process: Process{Key: "p", Params: []ProcessParam{{Name: "1host"}}},
+			invalid: true,
+		},
+		{
+			name:    "duplicate names",
+			process: Process{Key: "p", Params: []ProcessParam{{Name: "host"}, {Name: "host"}}},
+			invalid: true,
+		},
+		{
+			name:    "names differing by case",
+			process: Process{Key: "p", Params: []ProcessParam{{Name: "host"}, {Name: "Host"}}},
+			invalid: true,
+		},
+		{
+			name:    "legacy name differing by case",
+			process: Process{Key: "p", Param: "HOST", Params: []ProcessParam{{Name: "host"}}},
+			invalid: true,
+		},
+		{
+			name:    "unknown type",
+			process: Process{Key: "p", Params: []ProcessParam{{Name: "host", Type: "ipv4"}}},
+			invalid: true,
+		},
+		{
+			name:    "enum without values",
+			process: Process{Key: "p", Params: []ProcessParam{{Name: "mode", Type: ParamTypeEnum}}},
+			invalid: true,
+		},
+		{
+			name:    "invalid regex",
+			process: Process{Key: "p", Params: []ProcessParam{{Name: "host", Regex: "[a-z"}}},
+			invalid: true,
+		},
+		{
+			name:    "placeholder in sh script",
+			process: Process{Key: "p", Interpreter: InterpreterSh, Script: "echo '{host}'", Params: []ProcessParam{{Name: "host"}}},
+		},
+		{
+			name:    "placeholder as a word of exec script",
+			process: Process{Key: "p", Interpreter: InterpreterExec, Script: "ping -c 1 \"{host}\"", Params: []ProcessParam{{Name: "host"}}},
+		},
+		{
+			name:    "placeholder within a word of exec script",
+			process: Process{Key: "p", Interpreter: InterpreterExec, Script: "curl http://{host}/", Params: []ProcessParam{{Name: "host"}}},
+			invalid: true,
+		},
+		{
+			name:    "placeholder in python3 script",
+			process: Process{Key: "p", Interpreter: InterpreterPython3, Script: "print('{host}')", Params: []ProcessParam{{Name: "host"}}},
+			invalid: true,
+		},
+		{
+			name:    "environment in python3 script",
+			process: Process{Key: "p", Interpreter: InterpreterPython3, Script: "import os\nprint(os.environ['MANAGER_PARAM_HOST'])", Params: []ProcessParam{{Name: "host"}}},
+		},
+	}
+	for _, test := range tests {
+		err := test.process.postReadAdjustments()
+		if test.invalid && err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+		if !test.invalid && err != nil {
+			t.Errorf("%s: unexpected error: %+v", test.name, err)
+		}
+	}
+}
+
+func TestProcessParamNormalization(t *testing.T) {
+	process := &Process{Key: "p", Param: "path", Params: []ProcessParam{{Name: "count", Type: ParamTypeInt, Regex: "[0-9]{1,3}"}}}
+	if err := process.postReadAdjustments(); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if process.Interpreter != InterpreterBash {
+		t.Errorf("expected interpreter %s, got %s", InterpreterBash, process.Interpreter)
+	}
+	path := process.GetParam("path")
+	if path == nil || path.Type != ParamTypeString {
+		t.Fatalf("expected legacy param path declared as a string, got %+v", path)
+	}
+	if envName := path.EnvName(); envName != "MANAGER_PARAM_PATH" {
+		t.Errorf("expected environment variable MANAGER_PARAM_PATH, got %s", envName)
+	}
+	count := process.GetParam("count")
+	tests := []struct {
+		value   string
+		matches bool
+	}{
+		{"7", true},
+		{"123", true},
+		{"1234", false},
+		{"12a", false},
+	}
+	for _, test := range tests {
+		if matches := count.CompiledRegex().MatchString(test.value); matches != test.matches {
+			t.Errorf("count regex on %q: expected match %t, got %t", test.value, test.matches, matches)
+		}
+	}
+}
@@ -30,7 +30,7 @@ func OpenDb() (db *sql.DB, err error) {
 		return db, log.Errore(err)
 	} else if !fromCache {
 		// first time ever we talk to MySQL
-		query := fmt.Sprintf("create database if not exists %s", config.Config.BackendDb)
+		query := fmt.Sprintf("create database if not exists %s", config.Config().BackendDb)
 		if _, err := db.Exec(query); err != nil {
 			return db, log.Errore(err)
 		}
@@ -39,12 +39,12 @@ func OpenDb() (db *sql.DB, err error) {
 	db, fromCache, err = openDbMySQL()
 	if err == nil && !fromCache {
 		// do not show the password but do show what we connect to.
-		safeMySQLURI := fmt.Sprintf("%s:?@tcp(%s:%d)/%s?timeout=%ds", config.Config.BackendDbUser,
-			config.Config.BackendDbHosts, config.Config.BackendDbPort, config.Config.BackendDb, config.Config.MySQLConnectTimeoutSeconds)
+		safeMySQLURI := fmt.Sprintf("%s:?@tcp(%s:%d)/%s?timeout=%ds", config.Config().BackendDbUser,
+			config.Config().BackendDbHosts, config.Config().BackendDbPort, config.Config().BackendDb, config.Config().MySQLConnectTimeoutSeconds)
 		log.Debugf("Connected to backend db: %v", safeMySQLURI)
-		if config.Config.MySQLMaxPoolConnections > 0 {
-			log.Debugf("backend db pool SetMaxOpenConns: %d", config.Config.MySQLMaxPoolConnections)
-			db.SetMaxOpenConns(config.Config.MySQLMaxPoolConnections)
+		if config.Config().MySQLMaxPoolConnections > 0 {
+			log.Debugf("backend db pool SetMaxOpenConns: %d", config.Config().MySQLMaxPoolConnections)
+			db.SetMaxOpenConns(config.Config().MySQLMaxPoolConnections)
 		}
 	}
 
@@ -59,14 +59,14 @@ func OpenDb() (db *sql.DB, err error) {
 		// does not keep the maximum number of connections open but
 		// at the same time does not trigger disconnections and
 		// reconnections too frequently.
-		maxIdleConns := int(config.Config.MySQLMaxPoolConnections * 25 / 100)
+		maxIdleConns := int(config.Config().MySQLMaxPoolConnections * 25 / 100)
 		if maxIdleConns < 10 {
 			maxIdleConns = 10
 		}
 		log.Infof("Connecting to backend %s:%d: maxConnections: %d, maxIdleConns: %d",
-			config.Config.BackendDbHosts,
-			config.Config.BackendDbPort,
-			config.Config.MySQLMaxPoolConnections,
+			config.Config().BackendDbHosts,
+			config.Config().BackendDbPort,
+			config.Config().MySQLMaxPoolConnections,
 			maxIdleConns)
 		db.SetMaxIdleConns(maxIdleConns)
 	}
@@ -134,10 +134,10 @@ func openDbMySQLGeneric() (db *sql.DB, fromCache bool, err error) {
 	var uri string
 	var dbConn *sql.DB
 	var exists bool
-	for _, host := range strings.Split(config.Config.BackendDbHosts, ",") {
+	for _, host := range strings.Split(config.Config().BackendDbHosts, ",") {
 		uri = fmt.Sprintf("%s:%s@tcp(%s:%d)/?timeout=%ds&readTimeout=%ds&interpolateParams=true",
-			config.Config.BackendDbUser, config.Config.BackendDbPass, host, config.Config.BackendDbPort,
-			config.Config.MySQLConnectTimeoutSeconds, config.Config.MySQLReadTimeoutSeconds)
+			config.Config().BackendDbUser, config.Config().BackendDbPass, host, config.Config().BackendDbPort,
+			config.Config().MySQLConnectTimeoutSeconds, config.Config().MySQLReadTimeoutSeconds)
 		dbConn, exists, err = GetDB(uri)
 		if err == nil {
 			return dbConn, exists, err
@@ -147,16 +147,16 @@ func openDbMySQLGeneric() (db *sql.DB, fromCache bool, err error) {
 }
 
 func openDbMySQL() (db *sql.DB, fromCache bool, err error) {
-	for _, host := range strings.Split(config.Config.BackendDbHosts, ",") {
+	for _, host := range strings.Split(config.Config().BackendDbHosts, ",") {
 		uri := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?timeout=%ds&readTimeout=%ds&rejectReadOnly=%t&interpolateParams=true",
-			config.Config.BackendDbUser,
-			config.Config.BackendDbPass,
+			config.Config().BackendDbUser,
+			config.Config().BackendDbPass,
 			host,
-			config.Config.BackendDbPort,
-			config.Config.BackendDb,
-			config.Config.MySQLConnectTimeoutSeconds,
-			config.Config.MySQLReadTimeoutSeconds,
-			config.Config.MySQLRejectReadOnly,
+			config.Config().BackendDbPort,
+			config.Config().BackendDb,
+			config.Config().MySQLConnectTimeoutSeconds,
+			config.Config().MySQLReadTimeoutSeconds,
+			config.Config().MySQLRejectReadOnly,
 		)
 		db, fromCache, err = GetDB(uri)
 		if err == nil {
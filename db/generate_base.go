@@ -46,4 +46,68 @@ var generateSQLBase = []string{
   			PRIMARY KEY (anchor)
 		) ENGINE=InnoDB DEFAULT CHARSET=ascii
 	`,
+
+	`
+		CREATE TABLE IF NOT EXISTS process_job (
+			job_id varchar(128) CHARACTER SET ascii NOT NULL,
+			process_key varchar(128) NOT NULL,
+			hostname varchar(128) CHARACTER SET ascii NOT NULL,
+			token varchar(128) NOT NULL,
+			callback_url varchar(1024) CHARACTER SET utf8mb4 NOT NULL DEFAULT '',
+			status varchar(32) NOT NULL,
+			exit_code int(11) NOT NULL DEFAULT '0',
+			signal_name varchar(32) NOT NULL DEFAULT '',
+			output mediumtext CHARACTER SET utf8mb4,
+			stderr mediumtext CHARACTER SET utf8mb4,
+			stdout_truncated tinyint unsigned NOT NULL DEFAULT '0',
+			stderr_truncated tinyint unsigned NOT NULL DEFAULT '0',
+			error_message text CHARACTER SET utf8mb4,
+			submitted_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			start_time timestamp NULL DEFAULT NULL,
+			end_time timestamp NULL DEFAULT NULL,
+			PRIMARY KEY (job_id),
+			KEY process_key_idx_process_job (process_key, submitted_at),
+			KEY submitted_at_idx_process_job (submitted_at)
+		) ENGINE=InnoDB DEFAULT CHARSET=ascii
+	`,
+	`
+		CREATE TABLE IF NOT EXISTS process_audit (
+			audit_id bigint unsigned NOT NULL AUTO_INCREMENT,
+			audit_timestamp timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			process_key varchar(128) NOT NULL,
+			trigger_type varchar(32) NOT NULL,
+			user_name varchar(128) CHARACTER SET utf8mb4 NOT NULL DEFAULT '',
+			source_ip varchar(64) NOT NULL DEFAULT '',
+			hostname varchar(128) NOT NULL,
+			job_id varchar(128) NOT NULL DEFAULT '',
+			attempt int unsigned NOT NULL DEFAULT '1',
+			params text CHARACTER SET utf8mb4,
+			exit_code int(11) NOT NULL DEFAULT '0',
+			signal_name varchar(32) NOT NULL DEFAULT '',
+			error_message text CHARACTER SET utf8mb4,
+			duration_seconds double NOT NULL DEFAULT '0',
+			output_digest varchar(64) NOT NULL DEFAULT '',
+			PRIMARY KEY (audit_id),
+			KEY audit_timestamp_idx_process_audit (audit_timestamp),
+			KEY process_key_idx_process_audit (process_key, audit_timestamp),
+			KEY user_name_idx_process_audit (user_name, audit_timestamp)
+		) ENGINE=InnoDB DEFAULT CHARSET=ascii
+	`,
+	`
+		CREATE TABLE IF NOT EXISTS webhook_delivery (
+			delivery_id varchar(128) CHARACTER SET ascii NOT NULL,
+			job_id varchar(128) CHARACTER SET ascii NOT NULL,
+			process_key varchar(128) NOT NULL,
+			callback_url varchar(1024) CHARACTER SET utf8mb4 NOT NULL,
+			status varchar(32) NOT NULL,
+			attempts int unsigned NOT NULL DEFAULT '0',
+			last_status_code int(11) NOT NULL DEFAULT '0',
+			last_error text CHARACTER SET utf8mb4,
+			created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			last_attempt_at timestamp NULL DEFAULT NULL,
+			PRIMARY KEY (delivery_id),
+			KEY job_id_idx_webhook_delivery (job_id),
+			KEY created_at_idx_webhook_delivery (created_at)
+		) ENGINE=InnoDB DEFAULT CHARSET=ascii
+	`,
 }
@@ -6,12 +6,15 @@ import (
 	"io/ioutil"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/go-martini/martini"
 	"github.com/martini-contrib/auth"
 	"github.com/martini-contrib/render"
+	"github.com/openark/golib/log"
 
 	"github.com/github/my-manager/config"
+	"github.com/github/my-manager/logic"
 	"github.com/github/my-manager/process"
 	"github.com/github/my-manager/raft"
 	"github.com/github/my-manager/util"
@@ -24,6 +27,11 @@ type APIResponseCode int
 
 var registeredPaths = []string{}
 
+const defaultJobsLimit = 100
+
+// defaultRaftYieldTimeout is how long a leadership transfer waits for a new leader by default
+const defaultRaftYieldTimeout = 20 * time.Second
+
 const (
 	ERROR APIResponseCode = iota
 	OK
@@ -67,6 +75,12 @@ type APIResponse struct {
 	Details interface{}
 }
 
+// nodeStatus is the health of this node along with the usage of process concurrency limits
+type nodeStatus struct {
+	*process.HealthStatus
+	ProcessConcurrency [](*logic.ProcessConcurrencyStatus)
+}
+
 func Respond(r render.Render, apiResponse *APIResponse) {
 	r.JSON(apiResponse.Code.HttpStatus(), apiResponse)
 }
@@ -78,22 +92,49 @@ func Respond(r render.Render, apiResponse *APIResponse) {
 // point
 func (this *HttpAPI) StatusCheck(params martini.Params, r render.Render, req *http.Request) {
 	health, err := process.HealthTest()
+	status := &nodeStatus{HealthStatus: health, ProcessConcurrency: logic.ProcessConcurrency()}
 	if err != nil {
-		r.JSON(500, &APIResponse{Code: ERROR, Message: fmt.Sprintf("Application node is unhealthy %+v", err), Details: health})
+		r.JSON(500, &APIResponse{Code: ERROR, Message: fmt.Sprintf("Application node is unhealthy %+v", err), Details: status})
 		return
 	}
-	Respond(r, &APIResponse{Code: OK, Message: fmt.Sprintf("Application node is healthy"), Details: health})
+	Respond(r, &APIResponse{Code: OK, Message: fmt.Sprintf("Application node is healthy"), Details: status})
 }
 
 func (this *HttpAPI) registerSingleAPIRequest(m *martini.ClassicMartini, path string, handler martini.Handler, allowProxy bool) {
 	registeredPaths = append(registeredPaths, path)
 	fullPath := fmt.Sprintf("%s/api/%s", this.URLPrefix, path)
 
-	//if allowProxy && config.Config.RaftEnabled {
-	//	m.Get(fullPath, raftReverseProxy, handler)
-	//} else {
-	m.Get(fullPath, handler)
-	//}
+	if allowProxy && config.Config().RaftEnabled {
+		m.Get(fullPath, raftReverseProxy, handler)
+	} else {
+		m.Get(fullPath, handler)
+	}
+}
+
+// registerAPIPostRequest registers a POST API call. These are leader-only: followers forward
+// them to the leader.
+func (this *HttpAPI) registerAPIPostRequest(m *martini.ClassicMartini, path string, handler martini.Handler) {
+	registeredPaths = append(registeredPaths, path)
+	fullPath := fmt.Sprintf("%s/api/%s", this.URLPrefix, path)
+
+	if config.Config().RaftEnabled {
+		m.Post(fullPath, raftReverseProxy, handler)
+	} else {
+		m.Post(fullPath, handler)
+	}
+}
+
+// registerAPIReadRequest registers a GET API call served by any node from its own applied
+// state. Requests with ?consistent are forwarded to the leader by followers.
+func (this *HttpAPI) registerAPIReadRequest(m *martini.ClassicMartini, path string, handler martini.Handler) {
+	registeredPaths = append(registeredPaths, path)
+	fullPath := fmt.Sprintf("%s/api/%s", this.URLPrefix, path)
+
+	if config.Config().RaftEnabled {
+		m.Get(fullPath, consistentReadProxy, handler)
+	} else {
+		m.Get(fullPath, handler)
+	}
 }
 
 func (this *HttpAPI) getSynonymPath(path string) (synonymPath string) {
@@ -130,91 +171,367 @@ func (this *HttpAPI) registerAPIRequest(m *martini.ClassicMartini, path string,
 	this.registerAPIRequestInternal(m, path, handler, true)
 }
 
-func (this *HttpAPI) CommonRequest(params martini.Params, r render.Render, req *http.Request) {
-    var findFlag bool
-    var script string
-    var param string
-    var outputFlag string
-    process := config.Config.Processes
-    if len(process) < 1 {
-        err := fmt.Errorf("scripts in Processes is null")
-        r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
-        return
-    }
-    defer req.Body.Close()
-    body, err := ioutil.ReadAll(req.Body)
-    if err != nil {
-        r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
-        return
-    }
-    initInfo := string(body)
-    initInfoList := strings.Split(initInfo, "&")
-    if len(initInfoList) == 0 {
-        err := fmt.Errorf("parameter can not be null")
-        r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
-        return
-    }
-    for _, v := range initInfoList {
-        var dat map[string]string
-        err := json.Unmarshal([]byte(v),&dat)
-        if err != nil {
-            r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error() + " " + "Unmarshal params failed"})
-            return
-        }
-        for _,vmap := range process {
-            if v, ok := dat["key"]; ok {
-                if vmap["key"] == v {
-                    script = vmap["script"]
-                    param = vmap["param"]
-                    outputFlag = vmap["outputFlag"]
-                    findFlag = true
-                    break
-                }
-            } else {
-                r.JSON(500, &APIResponse{Code: ERROR, Message: "comman api must add 'key' param"})
-                return
-            }
-            
-        }
-
-        if !findFlag {
-            continue
-        } 
-
-        for _, prm := range strings.Split(param, ",") {
-                if v, ok := dat[prm];ok {
-                    script = strings.Replace(script, fmt.Sprintf("{%s}", prm), v, -1)
-                }
-        }
-
-        if len(script) == 0 {
-            continue
-        }
-        if outputFlag == "1" {
-            row, err := util.RunCommandOutput(script)
-            if err != nil {
-                r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
-                return
-            }
-            r.JSON(200, &APIResponse{Code: OK, Details: row})
-            return    
-        } else {
-            err := util.RunCommandNoOutput(script)
-            if err != nil {
-                r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
-                return
-            }
-            r.JSON(200, &APIResponse{Code: OK, Details: ""})
-            return
-        }            
-    }
-
-    if !findFlag || len(script) == 0 {
-        r.JSON(500, &APIResponse{Code: ERROR, Message: "find no script to run"})
-        return
-    } 
-    r.JSON(500, &APIResponse{Code: ERROR, Details: fmt.Sprintf("script :%s outputFlag:%s", script, outputFlag)})
-    return
+func (this *HttpAPI) CommonRequest(params martini.Params, r render.Render, req *http.Request, user auth.User) {
+	if len(logic.AllProcesses()) < 1 {
+		err := fmt.Errorf("scripts in Processes is null")
+		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	defer req.Body.Close()
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	initInfo := string(body)
+	initInfoList := strings.Split(initInfo, "&")
+	if len(initInfoList) == 0 {
+		err := fmt.Errorf("parameter can not be null")
+		r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	for _, v := range initInfoList {
+		var dat map[string]string
+		err := json.Unmarshal([]byte(v), &dat)
+		if err != nil {
+			r.JSON(500, &APIResponse{Code: ERROR, Message: err.Error() + " " + "Unmarshal params failed"})
+			return
+		}
+		key, ok := dat["key"]
+		if !ok {
+			r.JSON(500, &APIResponse{Code: ERROR, Message: "comman api must add 'key' param"})
+			return
+		}
+		proc, found := logic.GetProcess(key)
+		if !found || len(proc.Script) == 0 {
+			continue
+		}
+
+		command, err := logic.NewProcessCommand(proc, dat)
+		if err != nil {
+			r.JSON(http.StatusBadRequest, &APIResponse{Code: ERROR, Message: err.Error()})
+			return
+		}
+		if dat["async"] == "1" {
+			callbackURL, err := logic.JobCallbackURL(proc, dat["callbackUrl"])
+			if err != nil {
+				r.JSON(http.StatusBadRequest, &APIResponse{Code: ERROR, Message: err.Error()})
+				return
+			}
+			audit := logic.NewAuditEntry(proc, logic.AuditTriggerAsync, getUserId(req, user), getClientIP(req), dat)
+			job, err := logic.SubmitJob(proc, command, audit, callbackURL)
+			if err != nil {
+				status := 500
+				if logic.IsConcurrencyLimitError(err) || err == logic.ErrTooManyOutputStreams {
+					status = http.StatusTooManyRequests
+				}
+				r.JSON(status, &APIResponse{Code: ERROR, Message: err.Error()})
+				return
+			}
+			r.JSON(200, &APIResponse{Code: OK, Message: "job submitted", Details: job})
+			return
+		}
+		if dat["callbackUrl"] != "" {
+			r.JSON(http.StatusBadRequest, &APIResponse{Code: ERROR, Message: "callbackUrl only applies to async requests"})
+			return
+		}
+		audit := logic.NewAuditEntry(proc, logic.AuditTriggerAPI, getUserId(req, user), getClientIP(req), dat)
+		result, err := logic.RunProcessCommand(proc, command, audit)
+		if err != nil {
+			status := 500
+			if util.IsCommandTimedOut(err) {
+				status = http.StatusGatewayTimeout
+			}
+			if logic.IsConcurrencyLimitError(err) {
+				status = http.StatusTooManyRequests
+			}
+			if logic.IsClusterLockError(err) {
+				status = http.StatusConflict
+			}
+			r.JSON(status, &APIResponse{Code: ERROR, Message: err.Error(), Details: result})
+			return
+		}
+		r.JSON(200, &APIResponse{Code: OK, Details: result})
+		return
+	}
+
+	r.JSON(500, &APIResponse{Code: ERROR, Message: "find no script to run"})
+	return
+}
+
+// Batch runs a list of process invocations, sequentially or in parallel, and returns the
+// result of each
+func (this *HttpAPI) Batch(params martini.Params, r render.Render, req *http.Request, user auth.User) {
+	defer req.Body.Close()
+	request := &logic.BatchRequest{}
+	if err := json.NewDecoder(req.Body).Decode(request); err != nil {
+		r.JSON(http.StatusBadRequest, &APIResponse{Code: ERROR, Message: fmt.Sprintf("cannot decode batch: %+v", err)})
+		return
+	}
+	result, err := logic.RunBatch(request, getUserId(req, user), getClientIP(req))
+	if err != nil {
+		r.JSON(http.StatusBadRequest, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	code := OK
+	if result.Failed > 0 {
+		code = ERROR
+	}
+	message := fmt.Sprintf("%d succeeded, %d failed, %d skipped", result.Succeeded, result.Failed, result.Skipped)
+	Respond(r, &APIResponse{Code: code, Message: message, Details: result})
+}
+
+// Job returns the state and result of a single async job
+func (this *HttpAPI) Job(params martini.Params, r render.Render, req *http.Request) {
+	job, err := logic.ReadJob(params["jobId"])
+	if err != nil {
+		Respond(r, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	Respond(r, &APIResponse{Code: OK, Message: job.Status, Details: job})
+}
+
+// CancelJob terminates a running async job along with its process tree
+func (this *HttpAPI) CancelJob(params martini.Params, r render.Render, req *http.Request, user auth.User) {
+	if !isAuthorizedForAction(req, user) {
+		Respond(r, &APIResponse{Code: ERROR, Message: "Unauthorized"})
+		return
+	}
+	if err := logic.CancelJob(params["jobId"]); err != nil {
+		Respond(r, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	Respond(r, &APIResponse{Code: OK, Message: fmt.Sprintf("job %s canceled", params["jobId"])})
+}
+
+// Jobs returns the most recent async jobs, optionally for a given process key
+func (this *HttpAPI) Jobs(params martini.Params, r render.Render, req *http.Request) {
+	limit := util.ConvStrToUInt(req.URL.Query().Get("limit"))
+	if limit == 0 {
+		limit = defaultJobsLimit
+	}
+	jobs, err := logic.ReadRecentJobs(params["key"], limit)
+	if err != nil {
+		Respond(r, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	Respond(r, &APIResponse{Code: OK, Details: jobs})
+}
+
+// WebhookDeliveries returns the most recent job callback deliveries, optionally of a given job
+func (this *HttpAPI) WebhookDeliveries(params martini.Params, r render.Render, req *http.Request) {
+	limit := util.ConvStrToUInt(req.URL.Query().Get("limit"))
+	if limit == 0 {
+		limit = defaultJobsLimit
+	}
+	deliveries, err := logic.ReadWebhookDeliveries(params["jobId"], limit)
+	if err != nil {
+		Respond(r, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	Respond(r, &APIResponse{Code: OK, Details: deliveries})
+}
+
+// Schedules lists the scheduled processes along with their next fire time
+func (this *HttpAPI) Schedules(params martini.Params, r render.Render, req *http.Request) {
+	Respond(r, &APIResponse{Code: OK, Details: logic.ScheduledProcesses()})
+}
+
+// Processes lists the configured processes, their parameters, schedule and last run.
+// Scripts are only revealed to users authorized to make changes.
+func (this *HttpAPI) Processes(params martini.Params, r render.Render, req *http.Request, user auth.User) {
+	catalog, err := logic.ProcessCatalog(isAuthorizedForAction(req, user))
+	if err != nil {
+		Respond(r, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	if key := params["key"]; key != "" {
+		for _, entry := range catalog {
+			if entry.Key == key {
+				Respond(r, &APIResponse{Code: OK, Details: entry})
+				return
+			}
+		}
+		r.JSON(http.StatusNotFound, &APIResponse{Code: ERROR, Message: fmt.Sprintf("process not found: %s", key)})
+		return
+	}
+	Respond(r, &APIResponse{Code: OK, Details: catalog})
+}
+
+// readProcessDefinition decodes a process definition from the request body
+func readProcessDefinition(req *http.Request) (*config.Process, error) {
+	defer req.Body.Close()
+	proc := &config.Process{}
+	if err := json.NewDecoder(req.Body).Decode(proc); err != nil {
+		return nil, fmt.Errorf("cannot decode process definition: %+v", err)
+	}
+	return proc, nil
+}
+
+// CreateProcess adds a process definition to the replicated registry
+func (this *HttpAPI) CreateProcess(params martini.Params, r render.Render, req *http.Request, user auth.User) {
+	if !isAuthorizedForAction(req, user) {
+		Respond(r, &APIResponse{Code: ERROR, Message: "Unauthorized"})
+		return
+	}
+	proc, err := readProcessDefinition(req)
+	if err != nil {
+		r.JSON(http.StatusBadRequest, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	registered, err := logic.RegisterProcess(proc, true, 0)
+	if err != nil {
+		Respond(r, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	log.Infof("Process %s created by %s", proc.Key, getUserId(req, user))
+	Respond(r, &APIResponse{Code: OK, Message: fmt.Sprintf("Process created: %s", proc.Key), Details: registered})
+}
+
+// UpdateProcess replaces a process definition in the replicated registry. With ?version=N
+// the update only applies if the current definition is at version N.
+func (this *HttpAPI) UpdateProcess(params martini.Params, r render.Render, req *http.Request, user auth.User) {
+	if !isAuthorizedForAction(req, user) {
+		Respond(r, &APIResponse{Code: ERROR, Message: "Unauthorized"})
+		return
+	}
+	proc, err := readProcessDefinition(req)
+	if err != nil {
+		r.JSON(http.StatusBadRequest, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	if proc.Key == "" {
+		proc.Key = params["key"]
+	}
+	if proc.Key != params["key"] {
+		r.JSON(http.StatusBadRequest, &APIResponse{Code: ERROR, Message: fmt.Sprintf("process key %s does not match %s", proc.Key, params["key"])})
+		return
+	}
+	expectedVersion := uint64(util.ConvStrToUInt(req.URL.Query().Get("version")))
+	registered, err := logic.RegisterProcess(proc, false, expectedVersion)
+	if err != nil {
+		Respond(r, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	log.Infof("Process %s updated by %s", proc.Key, getUserId(req, user))
+	Respond(r, &APIResponse{Code: OK, Message: fmt.Sprintf("Process updated: %s", proc.Key), Details: registered})
+}
+
+// DeleteProcess removes a process definition from the replicated registry
+func (this *HttpAPI) DeleteProcess(params martini.Params, r render.Render, req *http.Request, user auth.User) {
+	if !isAuthorizedForAction(req, user) {
+		Respond(r, &APIResponse{Code: ERROR, Message: "Unauthorized"})
+		return
+	}
+	expectedVersion := uint64(util.ConvStrToUInt(req.URL.Query().Get("version")))
+	if err := logic.UnregisterProcess(params["key"], expectedVersion); err != nil {
+		Respond(r, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	log.Infof("Process %s deleted by %s", params["key"], getUserId(req, user))
+	Respond(r, &APIResponse{Code: OK, Message: fmt.Sprintf("Process deleted: %s", params["key"])})
+}
+
+// ProcessRegistry returns the replicated process registry as applied on this node
+func (this *HttpAPI) ProcessRegistry(params martini.Params, r render.Render, req *http.Request, user auth.User) {
+	registry := logic.ReadProcessRegistry()
+	if !isAuthorizedForAction(req, user) {
+		// Hide scripts, same as the process catalog does
+		redacted := &logic.ProcessRegistry{Version: registry.Version}
+		for _, registered := range registry.Processes {
+			proc := *registered.Process
+			proc.Script = ""
+			redacted.Processes = append(redacted.Processes, &logic.RegisteredProcess{Process: &proc, Version: registered.Version})
+		}
+		registry = redacted
+	}
+	Respond(r, &APIResponse{Code: OK, Message: fmt.Sprintf("version %d", registry.Version), Details: registry})
+}
+
+// ClusterLocks lists the cluster locks currently held
+func (this *HttpAPI) ClusterLocks(params martini.Params, r render.Render, req *http.Request) {
+	if !oraft.IsRaftEnabled() {
+		Respond(r, &APIResponse{Code: ERROR, Message: "raft-state: not running with raft setup"})
+		return
+	}
+	Respond(r, &APIResponse{Code: OK, Details: logic.ReadClusterLocks()})
+}
+
+// AcquireClusterLock is called by followers on the leader to acquire or renew a cluster lock
+func (this *HttpAPI) AcquireClusterLock(params martini.Params, r render.Render, req *http.Request, user auth.User) {
+	if !isAuthorizedForAction(req, user) {
+		Respond(r, &APIResponse{Code: ERROR, Message: "Unauthorized"})
+		return
+	}
+	if !oraft.IsLeader() {
+		Respond(r, &APIResponse{Code: ERROR, Message: "raft-state: not the leader"})
+		return
+	}
+	leaseSeconds := util.ConvStrToUInt(params["leaseSeconds"])
+	lock, err := logic.PublishAcquireClusterLock(params["name"], params["owner"], params["holderId"], leaseSeconds)
+	if err != nil {
+		if lockError, ok := err.(*logic.ClusterLockError); ok {
+			r.JSON(http.StatusConflict, &APIResponse{Code: ERROR, Message: err.Error(), Details: lockError.Lock})
+			return
+		}
+		Respond(r, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	Respond(r, &APIResponse{Code: OK, Message: fmt.Sprintf("cluster lock %s acquired", params["name"]), Details: lock})
+}
+
+// ReleaseClusterLock is called by followers on the leader to release a cluster lock
+func (this *HttpAPI) ReleaseClusterLock(params martini.Params, r render.Render, req *http.Request, user auth.User) {
+	if !isAuthorizedForAction(req, user) {
+		Respond(r, &APIResponse{Code: ERROR, Message: "Unauthorized"})
+		return
+	}
+	if !oraft.IsLeader() {
+		Respond(r, &APIResponse{Code: ERROR, Message: "raft-state: not the leader"})
+		return
+	}
+	if err := logic.PublishReleaseClusterLock(params["name"], params["holderId"]); err != nil {
+		Respond(r, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	Respond(r, &APIResponse{Code: OK, Message: fmt.Sprintf("cluster lock %s released", params["name"])})
+}
+
+// Audit returns a page of the process execution audit log, optionally filtered by the
+// key, trigger, user, sourceIp, hostname, jobId, since and until query parameters
+func (this *HttpAPI) Audit(params martini.Params, r render.Render, req *http.Request) {
+	page := int(util.ConvStrToUInt(params["page"]))
+	query := req.URL.Query()
+	filter := &logic.AuditFilter{
+		ProcessKey: query.Get("key"),
+		Trigger:    query.Get("trigger"),
+		User:       query.Get("user"),
+		SourceIP:   query.Get("sourceIp"),
+		Hostname:   query.Get("hostname"),
+		JobId:      query.Get("jobId"),
+		Since:      query.Get("since"),
+		Until:      query.Get("until"),
+	}
+	entries, err := logic.ReadAuditEntries(filter, page)
+	if err != nil {
+		Respond(r, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	Respond(r, &APIResponse{Code: OK, Message: fmt.Sprintf("page %d", page), Details: entries})
+}
+
+// ReloadConfiguration re-reads the configuration files on this node
+func (this *HttpAPI) ReloadConfiguration(params martini.Params, r render.Render, req *http.Request, user auth.User) {
+	if !isAuthorizedForAction(req, user) {
+		Respond(r, &APIResponse{Code: ERROR, Message: "Unauthorized"})
+		return
+	}
+	if err := logic.ReloadConfiguration(); err != nil {
+		Respond(r, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	log.Infof("Configuration reloaded by %s from %s", getUserId(req, user), getClientIP(req))
+	Respond(r, &APIResponse{Code: OK, Message: "Config reloaded"})
 }
 
 // RaftFollowerHealthReport is initiated by followers to report their identity and health to the raft leader.
@@ -231,22 +548,156 @@ func (this *HttpAPI) RaftFollowerHealthReport(params martini.Params, r render.Re
 	r.JSON(http.StatusOK, "health reported")
 }
 
+// RaftStatus returns the raft state of this node: term, log, commit and applied indexes,
+// snapshot and peers, and on the leader, when each member last reported its health
+func (this *HttpAPI) RaftStatus(params martini.Params, r render.Render, req *http.Request) {
+	status, err := oraft.GetRaftStatus()
+	if err != nil {
+		Respond(r, &APIResponse{Code: ERROR, Message: err.Error(), Details: status})
+		return
+	}
+	Respond(r, &APIResponse{Code: OK, Message: status.State, Details: status})
+}
+
+// raftYieldTimeout returns the time to wait for a new leader, from the "timeout" query
+// parameter in seconds, or a default
+func raftYieldTimeout(req *http.Request) time.Duration {
+	if timeoutSeconds := util.ConvStrToUInt(req.URL.Query().Get("timeout")); timeoutSeconds > 0 {
+		return time.Duration(timeoutSeconds) * time.Second
+	}
+	return defaultRaftYieldTimeout
+}
+
+// RaftYield hands leadership over to given raft peer, waiting until it is confirmed. Followers
+// forward it to the leader.
+func (this *HttpAPI) RaftYield(params martini.Params, r render.Render, req *http.Request, user auth.User) {
+	if !isAuthorizedForAction(req, user) {
+		Respond(r, &APIResponse{Code: ERROR, Message: "Unauthorized"})
+		return
+	}
+	log.Infof("Raft leadership yield to %s requested by %s from %s", params["node"], getUserId(req, user), getClientIP(req))
+	transfer, err := oraft.YieldToPeer(params["node"], raftYieldTimeout(req))
+	if err != nil {
+		Respond(r, &APIResponse{Code: ERROR, Message: fmt.Sprintf("Cannot yield raft leadership to %s: %+v", params["node"], err), Details: transfer})
+		return
+	}
+	Respond(r, &APIResponse{Code: OK, Message: fmt.Sprintf("Raft leadership taken by %s", transfer.Leader), Details: transfer})
+}
+
+// RaftYieldHint hands leadership over to a node whose hostname contains given hint, waiting
+// until a new leader is confirmed. Followers forward it to the leader.
+func (this *HttpAPI) RaftYieldHint(params martini.Params, r render.Render, req *http.Request, user auth.User) {
+	if !isAuthorizedForAction(req, user) {
+		Respond(r, &APIResponse{Code: ERROR, Message: "Unauthorized"})
+		return
+	}
+	log.Infof("Raft leadership yield by hint %s requested by %s from %s", params["hint"], getUserId(req, user), getClientIP(req))
+	transfer, err := oraft.YieldToHint(params["hint"], raftYieldTimeout(req))
+	if err != nil {
+		Respond(r, &APIResponse{Code: ERROR, Message: fmt.Sprintf("Cannot yield raft leadership by hint %s: %+v", params["hint"], err), Details: transfer})
+		return
+	}
+	Respond(r, &APIResponse{Code: OK, Message: fmt.Sprintf("Raft leadership taken by %s", transfer.Leader), Details: transfer})
+}
+
+// RaftPeers lists the raft peers as known to this node
+func (this *HttpAPI) RaftPeers(params martini.Params, r render.Render, req *http.Request) {
+	peers, err := oraft.GetPeers()
+	if err != nil {
+		Respond(r, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	Respond(r, &APIResponse{Code: OK, Details: peers})
+}
+
+// RaftJoin adds a node, by its raft address, to the raft cluster. Followers forward it to the leader.
+func (this *HttpAPI) RaftJoin(params martini.Params, r render.Render, req *http.Request, user auth.User) {
+	if !isAuthorizedForAction(req, user) {
+		Respond(r, &APIResponse{Code: ERROR, Message: "Unauthorized"})
+		return
+	}
+	peer, err := oraft.AddPeer(params["addr"])
+	if err != nil {
+		Respond(r, &APIResponse{Code: ERROR, Message: fmt.Sprintf("Cannot add raft peer %s: %+v", peer, err)})
+		return
+	}
+	log.Infof("Raft peer %s added by %s from %s", peer, getUserId(req, user), getClientIP(req))
+	Respond(r, &APIResponse{Code: OK, Message: fmt.Sprintf("Raft peer added: %s", peer), Details: peer})
+}
+
+// RaftLeave removes a node, by its raft address, from the raft cluster. Followers forward it to the leader.
+func (this *HttpAPI) RaftLeave(params martini.Params, r render.Render, req *http.Request, user auth.User) {
+	if !isAuthorizedForAction(req, user) {
+		Respond(r, &APIResponse{Code: ERROR, Message: "Unauthorized"})
+		return
+	}
+	peer, err := oraft.RemovePeer(params["addr"])
+	if err != nil {
+		Respond(r, &APIResponse{Code: ERROR, Message: fmt.Sprintf("Cannot remove raft peer %s: %+v", peer, err)})
+		return
+	}
+	log.Infof("Raft peer %s removed by %s from %s", peer, getUserId(req, user), getClientIP(req))
+	Respond(r, &APIResponse{Code: OK, Message: fmt.Sprintf("Raft peer removed: %s", peer), Details: peer})
+}
+
 // RegisterRequests makes for the de-facto list of known API calls
 func (this *HttpAPI) RegisterRequests(m *martini.ClassicMartini) {
 	var apiEndpoint string
 	this.registerAPIRequestNoProxy(m, "raft-follower-health-report/:authenticationToken/:raftBind/:raftAdvertise", this.RaftFollowerHealthReport)
 	this.registerAPIRequest(m, "version", this.GetAppVersion)
-	if config.Config.ApiEndpoint != "" {
-		apiEndpoint = config.Config.ApiEndpoint
+	this.registerAPIRequestNoProxy(m, "raft-status", this.RaftStatus)
+	this.registerAPIRequestNoProxy(m, "raft-peers", this.RaftPeers)
+	this.registerAPIRequest(m, "raft-join/:addr", this.RaftJoin)
+	this.registerAPIRequest(m, "raft-leave/:addr", this.RaftLeave)
+	this.registerAPIRequest(m, "raft-yield/:node", this.RaftYield)
+	this.registerAPIRequest(m, "raft-yield-hint/:hint", this.RaftYieldHint)
+	this.registerAPIRequestNoProxy(m, "processes", this.Processes)
+	this.registerAPIRequestNoProxy(m, "process/:key", this.Processes)
+	this.registerAPIRequestNoProxy(m, "process-registry", this.ProcessRegistry)
+	this.registerAPIPostRequest(m, "create-process", this.CreateProcess)
+	this.registerAPIPostRequest(m, "update-process/:key", this.UpdateProcess)
+	this.registerAPIRequest(m, "delete-process/:key", this.DeleteProcess)
+	this.registerAPIPostRequest(m, "batch", this.Batch)
+	this.registerAPIRequestNoProxy(m, "cluster-locks", this.ClusterLocks)
+	this.registerAPIRequestNoProxy(m, "acquire-cluster-lock/:name/:owner/:holderId/:leaseSeconds", this.AcquireClusterLock)
+	this.registerAPIRequestNoProxy(m, "release-cluster-lock/:name/:holderId", this.ReleaseClusterLock)
+	this.registerAPIReadRequest(m, "kv", this.KVList)
+	this.registerAPIReadRequest(m, "kv/**", this.KVGet)
+	this.registerAPIPostRequest(m, "kv/**", this.KVPut)
+	this.registerAPIRequest(m, "kv-delete/**", this.KVDelete)
+	this.registerAPIRequestNoProxy(m, "watch-kv/**", this.WatchKV)
+	this.registerAPIRequestNoProxy(m, "watch-leader-uri", this.WatchLeaderURI)
+	this.registerAPIRequestNoProxy(m, "schedules", this.Schedules)
+	this.registerAPIRequestNoProxy(m, "job/:jobId", this.Job)
+	this.registerAPIRequest(m, "job-output/:jobId", this.JobOutput)
+	streamingPathPrefixes = append(streamingPathPrefixes, fmt.Sprintf("%s/api/job-output/", this.URLPrefix))
+	this.registerAPIRequest(m, "cancel-job/:jobId", this.CancelJob)
+	this.registerAPIRequestNoProxy(m, "jobs", this.Jobs)
+	this.registerAPIRequestNoProxy(m, "jobs/:key", this.Jobs)
+	this.registerAPIRequestNoProxy(m, "webhook-deliveries", this.WebhookDeliveries)
+	this.registerAPIRequestNoProxy(m, "webhook-deliveries/:jobId", this.WebhookDeliveries)
+	this.registerAPIRequestNoProxy(m, "reload-configuration", this.ReloadConfiguration)
+	this.registerAPIRequestNoProxy(m, "audit", this.Audit)
+	this.registerAPIRequestNoProxy(m, "audit/:page", this.Audit)
+	if config.Config().ApiEndpoint != "" {
+		apiEndpoint = config.Config().ApiEndpoint
 	} else {
 		apiEndpoint = config.DefaultApiEndpoint
 	}
-	m.Post(apiEndpoint, this.CommonRequest)
+	streamEndpoint := apiEndpoint + "/stream"
+	streamingPathPrefixes = append(streamingPathPrefixes, streamEndpoint)
+	if config.Config().RaftEnabled {
+		m.Post(apiEndpoint, raftReverseProxy, this.CommonRequest)
+		m.Post(streamEndpoint, raftReverseProxy, this.StreamRequest)
+	} else {
+		m.Post(apiEndpoint, this.CommonRequest)
+		m.Post(streamEndpoint, this.StreamRequest)
+	}
 
 	// Configurable status check endpoint
-	if config.Config.StatusEndpoint == config.DefaultStatusAPIEndpoint {
+	if config.Config().StatusEndpoint == config.DefaultStatusAPIEndpoint {
 		this.registerAPIRequestNoProxy(m, "status", this.StatusCheck)
 	} else {
-		m.Get(config.Config.StatusEndpoint, this.StatusCheck)
+		m.Get(config.Config().StatusEndpoint, this.StatusCheck)
 	}
 }
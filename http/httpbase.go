@@ -0,0 +1,110 @@
+package http
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/martini-contrib/auth"
+
+	"github.com/github/my-manager/config"
+	"github.com/github/my-manager/raft"
+)
+
+// getUserId returns the authenticated user making the request, if any
+func getUserId(req *http.Request, user auth.User) string {
+	if strings.ToLower(config.Config().AuthenticationMethod) == "proxy" {
+		return req.Header.Get(config.Config().AuthUserHeader)
+	}
+	return string(user)
+}
+
+// isAuthorizedForAction checks whether the user making the request may make changes
+func isAuthorizedForAction(req *http.Request, user auth.User) bool {
+	switch strings.ToLower(config.Config().AuthenticationMethod) {
+	case "basic":
+		{
+			// The mere fact we're here means the user has passed authentication
+			return true
+		}
+	case "multi":
+		{
+			return string(user) != "readonly"
+		}
+	case "proxy":
+		{
+			authUser := getUserId(req, user)
+			if authUser != "" && authUser == config.Config().RaftNodeAuthUser {
+				// Raft nodes calling the leader on their own behalf
+				return isRaftPeerHost(getRemoteIP(req))
+			}
+			for _, configPowerAuthUser := range config.Config().PowerAuthUsers {
+				if configPowerAuthUser == "*" || configPowerAuthUser == authUser {
+					return true
+				}
+			}
+			return false
+		}
+	default:
+		{
+			// Default: no authentication method
+			return true
+		}
+	}
+}
+
+// isRaftPeerHost tells whether given IP belongs to a raft peer. Peers are taken from raft,
+// or from RaftNodes when raft is not running.
+func isRaftPeerHost(ip string) bool {
+	peers, err := oraft.GetPeers()
+	if err != nil || len(peers) == 0 {
+		peers = config.Config().RaftNodes
+	}
+	for _, peer := range peers {
+		peerHost, _, err := net.SplitHostPort(peer)
+		if err != nil {
+			// No port
+			peerHost = peer
+		}
+		if peerHost == ip {
+			return true
+		}
+		if net.ParseIP(peerHost) != nil {
+			continue
+		}
+		addrs, err := net.LookupHost(peerHost)
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if addr == ip {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// getRemoteIP returns the address of the immediate peer of the connection
+func getRemoteIP(req *http.Request) string {
+	remoteIP, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return remoteIP
+}
+
+// getClientIP returns the address of the client making the request. For requests forwarded
+// by a follower, this is the client address the follower appended to X-Forwarded-For. The
+// forwarding headers are only honored when the request comes from a raft peer, as any client
+// could set them.
+func getClientIP(req *http.Request) string {
+	remoteIP := getRemoteIP(req)
+	if req.Header.Get(ForwardedByHeader) != "" && isRaftPeerHost(remoteIP) {
+		forwardedFor := strings.Split(req.Header.Get("X-Forwarded-For"), ",")
+		if clientIP := strings.TrimSpace(forwardedFor[len(forwardedFor)-1]); clientIP != "" {
+			return clientIP
+		}
+	}
+	return remoteIP
+}
@@ -0,0 +1,130 @@
+package http
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/auth"
+	"github.com/martini-contrib/render"
+	"github.com/openark/golib/log"
+
+	"github.com/github/my-manager/logic"
+)
+
+// kvCas reads the optional "cas" query parameter: the index a key must be at for a write to
+// apply, 0 meaning the key must not exist
+func kvCas(req *http.Request) (cas bool, casIndex uint64, err error) {
+	values, cas := req.URL.Query()["cas"]
+	if !cas {
+		return false, 0, nil
+	}
+	if casIndex, err = strconv.ParseUint(values[0], 10, 64); err != nil {
+		return false, 0, fmt.Errorf("invalid cas index: %q", values[0])
+	}
+	return true, casIndex, nil
+}
+
+// kvTTL reads the optional "ttl" query parameter, in seconds, 0 meaning no expiry
+func kvTTL(req *http.Request) (ttlSeconds uint, err error) {
+	value := req.URL.Query().Get("ttl")
+	if value == "" {
+		return 0, nil
+	}
+	ttl, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid ttl: %q", value)
+	}
+	return uint(ttl), nil
+}
+
+// respondKVError responds to a failed write, with the current entry on a failed compare-and-swap
+func respondKVError(r render.Render, err error) {
+	if casError, ok := err.(*logic.KVCasError); ok {
+		r.JSON(http.StatusConflict, &APIResponse{Code: ERROR, Message: err.Error(), Details: casError.Entry})
+		return
+	}
+	Respond(r, &APIResponse{Code: ERROR, Message: err.Error()})
+}
+
+// KVGet returns a key of the replicated key-value store, as applied on this node, or on the
+// leader with ?consistent. With ?raw, only the value is returned, as text.
+func (this *HttpAPI) KVGet(params martini.Params, r render.Render, w http.ResponseWriter, req *http.Request) {
+	key := params["_1"]
+	entry, found := logic.ReadKVEntry(key)
+	if !found {
+		r.JSON(http.StatusNotFound, &APIResponse{Code: ERROR, Message: fmt.Sprintf("key not found: %s", key)})
+		return
+	}
+	if _, raw := req.URL.Query()["raw"]; raw {
+		r.Text(http.StatusOK, entry.Value)
+		return
+	}
+	Respond(r, &APIResponse{Code: OK, Details: entry})
+}
+
+// KVList returns the keys of the replicated key-value store beginning with the "prefix" query
+// parameter, as applied on this node, or on the leader with ?consistent
+func (this *HttpAPI) KVList(params martini.Params, r render.Render, req *http.Request) {
+	prefix := req.URL.Query().Get("prefix")
+	entries := logic.ReadKVEntries(prefix)
+	Respond(r, &APIResponse{Code: OK, Message: fmt.Sprintf("%d keys", len(entries)), Details: entries})
+}
+
+// KVPut sets a key of the replicated key-value store to the request body. ?ttl=N expires the
+// key after N seconds, and ?cas=N only sets it if it is at index N, 0 meaning it must not
+// exist. Followers forward it to the leader.
+func (this *HttpAPI) KVPut(params martini.Params, r render.Render, req *http.Request, user auth.User) {
+	if !isAuthorizedForAction(req, user) {
+		Respond(r, &APIResponse{Code: ERROR, Message: "Unauthorized"})
+		return
+	}
+	defer req.Body.Close()
+	value, err := ioutil.ReadAll(io.LimitReader(req.Body, logic.MaxKVValueBytes+1))
+	if err != nil {
+		r.JSON(http.StatusBadRequest, &APIResponse{Code: ERROR, Message: fmt.Sprintf("cannot read value: %+v", err)})
+		return
+	}
+	key := params["_1"]
+	ttlSeconds, err := kvTTL(req)
+	if err != nil {
+		r.JSON(http.StatusBadRequest, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	cas, casIndex, err := kvCas(req)
+	if err != nil {
+		r.JSON(http.StatusBadRequest, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	entry, err := logic.PutKV(key, string(value), ttlSeconds, cas, casIndex)
+	if err != nil {
+		respondKVError(r, err)
+		return
+	}
+	log.Debugf("Key %s set by %s at index %d", key, getUserId(req, user), entry.ModifyIndex)
+	Respond(r, &APIResponse{Code: OK, Message: fmt.Sprintf("Key set: %s", key), Details: entry})
+}
+
+// KVDelete removes a key of the replicated key-value store. ?cas=N only removes it if it is
+// at index N. Followers forward it to the leader.
+func (this *HttpAPI) KVDelete(params martini.Params, r render.Render, req *http.Request, user auth.User) {
+	if !isAuthorizedForAction(req, user) {
+		Respond(r, &APIResponse{Code: ERROR, Message: "Unauthorized"})
+		return
+	}
+	key := params["_1"]
+	cas, casIndex, err := kvCas(req)
+	if err != nil {
+		r.JSON(http.StatusBadRequest, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	if err := logic.DeleteKV(key, cas, casIndex); err != nil {
+		respondKVError(r, err)
+		return
+	}
+	log.Debugf("Key %s deleted by %s", key, getUserId(req, user))
+	Respond(r, &APIResponse{Code: OK, Message: fmt.Sprintf("Key deleted: %s", key)})
+}
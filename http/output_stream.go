@@ -0,0 +1,162 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/auth"
+	"github.com/martini-contrib/render"
+
+	"github.com/github/my-manager/logic"
+	"github.com/github/my-manager/process"
+)
+
+// JobIdHeader names the job whose output a streaming response carries
+const JobIdHeader = "X-My-Manager-Job-Id"
+
+// streamingPathPrefixes are paths whose responses are streamed, and must not be compressed
+var streamingPathPrefixes = []string{}
+
+// UncompressedStreams is a middleware, preceding gzip, which keeps streamed responses
+// uncompressed: the gzip writer buffers output and cannot be flushed.
+func UncompressedStreams(req *http.Request) {
+	for _, prefix := range streamingPathPrefixes {
+		if strings.HasPrefix(req.URL.Path, prefix) {
+			req.Header.Del("Accept-Encoding")
+			return
+		}
+	}
+}
+
+// isServerSentEvents tells whether the client asks for Server-Sent Events rather than JSON lines
+func isServerSentEvents(req *http.Request) bool {
+	return req.URL.Query().Get("format") == "sse" || strings.Contains(req.Header.Get("Accept"), "text/event-stream")
+}
+
+// writeOutputStream sends the output of a job from given offset on, as JSON lines or as
+// Server-Sent Events, flushing each record as it is produced. It returns once the final
+// record is sent or the client goes away.
+func writeOutputStream(w http.ResponseWriter, req *http.Request, stream *logic.OutputStream, offset int64) {
+	sse := isServerSentEvents(req)
+	if sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.Header().Set(JobIdHeader, stream.JobId)
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+
+	for {
+		records, finished, err := stream.Read(req.Context(), offset)
+		if err != nil {
+			// Client went away
+			return
+		}
+		for _, record := range records {
+			data, err := json.Marshal(record)
+			if err != nil {
+				return
+			}
+			if sse {
+				_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", record.Offset, record.Stream, data)
+			} else {
+				_, err = fmt.Fprintf(w, "%s\n", data)
+			}
+			if err != nil {
+				return
+			}
+			offset = record.Offset + 1
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if finished {
+			return
+		}
+	}
+}
+
+// StreamRequest runs a single process invocation, as sent to the common endpoint, in the
+// background and streams its output as it is produced, ending with a record holding the
+// exit code. The job id is returned in a header, for resuming the stream via job-output.
+func (this *HttpAPI) StreamRequest(params martini.Params, r render.Render, w http.ResponseWriter, req *http.Request, user auth.User) {
+	defer req.Body.Close()
+	var dat map[string]string
+	if err := json.NewDecoder(req.Body).Decode(&dat); err != nil {
+		r.JSON(http.StatusBadRequest, &APIResponse{Code: ERROR, Message: err.Error() + " " + "Unmarshal params failed"})
+		return
+	}
+	proc, found := logic.GetProcess(dat["key"])
+	if !found || len(proc.Script) == 0 {
+		r.JSON(http.StatusNotFound, &APIResponse{Code: ERROR, Message: fmt.Sprintf("no script to run for key %s", dat["key"])})
+		return
+	}
+	if !proc.IsOutputCaptured() {
+		r.JSON(http.StatusBadRequest, &APIResponse{Code: ERROR, Message: fmt.Sprintf("process %s does not capture its output; it needs outputFlag \"1\" to be streamed", proc.Key)})
+		return
+	}
+	command, err := logic.NewProcessCommand(proc, dat)
+	if err != nil {
+		r.JSON(http.StatusBadRequest, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	callbackURL, err := logic.JobCallbackURL(proc, dat["callbackUrl"])
+	if err != nil {
+		r.JSON(http.StatusBadRequest, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	audit := logic.NewAuditEntry(proc, logic.AuditTriggerStream, getUserId(req, user), getClientIP(req), dat)
+	job, err := logic.SubmitJob(proc, command, audit, callbackURL)
+	if err != nil {
+		status := 500
+		if logic.IsConcurrencyLimitError(err) || err == logic.ErrTooManyOutputStreams {
+			status = http.StatusTooManyRequests
+		}
+		r.JSON(status, &APIResponse{Code: ERROR, Message: err.Error()})
+		return
+	}
+	stream, found := logic.GetOutputStream(job.JobId)
+	if !found {
+		r.JSON(500, &APIResponse{Code: ERROR, Message: fmt.Sprintf("no output stream for job %s", job.JobId)})
+		return
+	}
+	writeOutputStream(w, req, stream, 0)
+}
+
+// JobOutput streams the buffered and upcoming output of a job from given offset, which is
+// taken from the "offset" query parameter or, for reconnecting SSE clients, Last-Event-ID
+func (this *HttpAPI) JobOutput(params martini.Params, r render.Render, w http.ResponseWriter, req *http.Request) {
+	var offset int64
+	if lastEventId := req.Header.Get("Last-Event-ID"); lastEventId != "" {
+		lastOffset, err := strconv.ParseInt(lastEventId, 10, 64)
+		if err != nil {
+			r.JSON(http.StatusBadRequest, &APIResponse{Code: ERROR, Message: fmt.Sprintf("invalid Last-Event-ID: %s", lastEventId)})
+			return
+		}
+		offset = lastOffset + 1
+	}
+	if offsetParam := req.URL.Query().Get("offset"); offsetParam != "" {
+		var err error
+		if offset, err = strconv.ParseInt(offsetParam, 10, 64); err != nil {
+			r.JSON(http.StatusBadRequest, &APIResponse{Code: ERROR, Message: fmt.Sprintf("invalid offset: %s", offsetParam)})
+			return
+		}
+	}
+	stream, found := logic.GetOutputStream(params["jobId"])
+	if !found {
+		message := fmt.Sprintf("output of job %s is not buffered on this node", params["jobId"])
+		if job, err := logic.ReadJob(params["jobId"]); err == nil && job.Hostname != process.ThisHostname {
+			message = fmt.Sprintf("%s; it is owned by %s", message, job.Hostname)
+		}
+		r.JSON(http.StatusNotFound, &APIResponse{Code: ERROR, Message: message})
+		return
+	}
+	writeOutputStream(w, req, stream, offset)
+}
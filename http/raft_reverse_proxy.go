@@ -0,0 +1,87 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+
+	"github.com/github/my-manager/process"
+	"github.com/github/my-manager/raft"
+
+	"github.com/go-martini/martini"
+	"github.com/openark/golib/log"
+)
+
+const (
+	// ForwardedByHeader is set on requests proxied to the leader, naming the forwarding node
+	ForwardedByHeader = "X-My-Manager-Forwarded-By"
+	// ServedByHeader names the node which actually served a request
+	ServedByHeader = "X-My-Manager-Served-By"
+)
+
+var leaderProxyTransport http.RoundTripper
+var leaderProxyTransportOnce sync.Once
+
+// ServedBy is a middleware stamping every response with the identity of this node
+func ServedBy(w http.ResponseWriter) {
+	w.Header().Set(ServedByHeader, process.ThisHostname)
+}
+
+// raftReverseProxy forwards leader-only requests received by a follower to the raft leader.
+// Method, body and headers, including authentication, are passed along as they are.
+// A request which was already forwarded is never forwarded again.
+func raftReverseProxy(w http.ResponseWriter, r *http.Request, c martini.Context) {
+	if !oraft.IsRaftEnabled() {
+		// No raft, so no reverse proxy to the leader
+		return
+	}
+	if oraft.IsLeader() {
+		// I am the leader. I will handle the request directly.
+		return
+	}
+	if oraft.GetLeader() == "" {
+		http.Error(w, "raft leader unknown; cannot forward request", http.StatusServiceUnavailable)
+		return
+	}
+	if forwardedBy := r.Header.Get(ForwardedByHeader); forwardedBy != "" {
+		// Leadership changed while in transit. Refuse rather than risk a forwarding loop.
+		http.Error(w, fmt.Sprintf("request forwarded by %s reached a non-leader; not forwarding again", forwardedBy), http.StatusServiceUnavailable)
+		return
+	}
+	if oraft.LeaderURI.IsThisLeaderURI() {
+		http.Error(w, "raft leader URI points to this non-leader node; cannot forward request", http.StatusServiceUnavailable)
+		return
+	}
+	leaderURI, err := url.Parse(oraft.LeaderURI.Get())
+	if err != nil {
+		log.Errore(err)
+		http.Error(w, fmt.Sprintf("cannot parse raft leader URI: %+v", err), http.StatusServiceUnavailable)
+		return
+	}
+	leaderProxyTransportOnce.Do(func() {
+		leaderProxyTransport = oraft.NewHttpTransport()
+	})
+	log.Debugf("raftReverseProxy: forwarding %s %s to %s", r.Method, r.URL.Path, leaderURI.String())
+
+	// Let the leader decide on compression; our own gzip middleware applies on the way back
+	r.Header.Del("Accept-Encoding")
+	r.Header.Set(ForwardedByHeader, process.ThisHostname)
+	// The leader stamps its own identity
+	w.Header().Del(ServedByHeader)
+
+	proxy := httputil.NewSingleHostReverseProxy(leaderURI)
+	proxy.Transport = leaderProxyTransport
+	// Pass streamed output along as soon as the leader sends it
+	proxy.FlushInterval = -1
+	proxy.ServeHTTP(w, r)
+}
+
+// consistentReadProxy forwards reads asking for ?consistent to the raft leader, so that they
+// reflect the changes the leader committed rather than the possibly lagging state of a follower
+func consistentReadProxy(w http.ResponseWriter, r *http.Request, c martini.Context) {
+	if _, consistent := r.URL.Query()["consistent"]; consistent {
+		raftReverseProxy(w, r, c)
+	}
+}
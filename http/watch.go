@@ -0,0 +1,59 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+
+	"github.com/github/my-manager/logic"
+	"github.com/github/my-manager/util"
+)
+
+const (
+	defaultWatchTimeout = 60 * time.Second
+	maxWatchTimeout     = 10 * time.Minute
+)
+
+// watchParams reads the "index" query parameter, the last index the client saw, and the
+// "timeout" one, in seconds
+func watchParams(req *http.Request) (afterIndex uint64, timeout time.Duration) {
+	query := req.URL.Query()
+	afterIndex = uint64(util.ConvStrToUInt(query.Get("index")))
+	timeout = defaultWatchTimeout
+	if timeoutSeconds := util.ConvStrToUInt(query.Get("timeout")); timeoutSeconds > 0 {
+		timeout = time.Duration(timeoutSeconds) * time.Second
+	}
+	if timeout > maxWatchTimeout {
+		timeout = maxWatchTimeout
+	}
+	return afterIndex, timeout
+}
+
+// WatchKV blocks until a key of the replicated key-value store, or with ?prefix any key
+// beginning with it, changes beyond ?index=N, or until ?timeout=S seconds pass. It is served
+// from the state applied on this node, leader or follower. The returned Index is to be passed
+// in the next call; without an index, the call returns at once.
+func (this *HttpAPI) WatchKV(params martini.Params, r render.Render, req *http.Request) {
+	key := params["_1"]
+	_, prefix := req.URL.Query()["prefix"]
+	if !prefix {
+		if err := logic.ValidateKVKey(key); err != nil {
+			r.JSON(http.StatusBadRequest, &APIResponse{Code: ERROR, Message: err.Error()})
+			return
+		}
+	}
+	afterIndex, timeout := watchParams(req)
+	result := logic.WatchKV(req.Context(), key, prefix, afterIndex, timeout)
+	Respond(r, &APIResponse{Code: OK, Message: fmt.Sprintf("index %d", result.Index), Details: result})
+}
+
+// WatchLeaderURI blocks until the raft leader URI changes beyond ?index=N, or until
+// ?timeout=S seconds pass. It is served from the state applied on this node.
+func (this *HttpAPI) WatchLeaderURI(params martini.Params, r render.Render, req *http.Request) {
+	afterIndex, timeout := watchParams(req)
+	result := logic.WatchLeaderURI(req.Context(), afterIndex, timeout)
+	Respond(r, &APIResponse{Code: OK, Message: fmt.Sprintf("index %d", result.Index), Details: result})
+}
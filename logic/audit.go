@@ -0,0 +1,111 @@
+package logic
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/github/my-manager/config"
+	"github.com/github/my-manager/process"
+	"github.com/github/my-manager/util"
+
+	"github.com/openark/golib/log"
+)
+
+const (
+	AuditTriggerAPI      = "api"
+	AuditTriggerAsync    = "async"
+	AuditTriggerBatch    = "batch"
+	AuditTriggerSchedule = "schedule"
+	AuditTriggerStream   = "stream"
+)
+
+// AuditEntry records a single execution of a configured process: who triggered it,
+// with which parameters, and how it ended
+type AuditEntry struct {
+	AuditId         int64
+	AuditTimestamp  string
+	ProcessKey      string
+	Trigger         string
+	User            string
+	SourceIP        string
+	Hostname        string
+	JobId           string
+	Attempt         int // 1 for the first attempt of a run, incremented with each retry
+	Params          map[string]string
+	ExitCode        int
+	Signal          string
+	ErrorMessage    string
+	DurationSeconds float64
+	OutputDigest    string // sha256 of stdout followed by stderr
+}
+
+// AuditFilter narrows down audit entries returned by ReadAuditEntries. Empty fields match all.
+type AuditFilter struct {
+	ProcessKey string
+	Trigger    string
+	User       string
+	SourceIP   string
+	Hostname   string
+	JobId      string
+	Since      string
+	Until      string
+}
+
+// NewAuditEntry prepares the audit entry of an execution about to start. Only declared
+// parameters are recorded, and values of secret ones are masked.
+func NewAuditEntry(proc *config.Process, trigger string, user string, sourceIP string, values map[string]string) *AuditEntry {
+	return &AuditEntry{
+		ProcessKey: proc.Key,
+		Trigger:    trigger,
+		User:       user,
+		SourceIP:   sourceIP,
+		Hostname:   process.ThisHostname,
+		Attempt:    1,
+		Params:     redactParams(proc, values),
+	}
+}
+
+func redactParams(proc *config.Process, values map[string]string) map[string]string {
+	params := make(map[string]string)
+	for _, param := range proc.Params {
+		value, provided := values[param.Name]
+		if !provided {
+			continue
+		}
+		if param.IsSecret() {
+			value = util.RedactedValue
+		} else {
+			value = util.Redact(value)
+		}
+		params[param.Name] = value
+	}
+	return params
+}
+
+// applyResult copies the outcome of the execution onto the audit entry
+func (audit *AuditEntry) applyResult(result *util.CommandResult, err error) {
+	audit.ExitCode = -1
+	if err != nil {
+		audit.ErrorMessage = err.Error()
+	}
+	if result == nil {
+		return
+	}
+	audit.ExitCode = result.ExitCode
+	audit.Signal = result.Signal
+	audit.DurationSeconds = result.DurationSeconds
+
+	digest := sha256.New()
+	digest.Write([]byte(result.Stdout))
+	digest.Write([]byte(result.Stderr))
+	audit.OutputDigest = hex.EncodeToString(digest.Sum(nil))
+}
+
+// auditExecution completes the audit entry with the outcome of the execution and persists it.
+// Failing to write the audit log is reported but does not fail the execution.
+func auditExecution(audit *AuditEntry, result *util.CommandResult, err error) {
+	audit.applyResult(result, err)
+	if err := writeAuditEntry(audit); err != nil {
+		log.Errorf("cannot write audit entry of process %s triggered by %s: %+v", audit.ProcessKey, audit.Trigger, err)
+	}
+}
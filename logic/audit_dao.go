@@ -0,0 +1,136 @@
+package logic
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/github/my-manager/config"
+	"github.com/github/my-manager/db"
+
+	"github.com/openark/golib/log"
+	"github.com/openark/golib/sqlutils"
+)
+
+// writeAuditEntry persists an audit entry to the process_audit table
+func writeAuditEntry(audit *AuditEntry) error {
+	params, err := json.Marshal(audit.Params)
+	if err != nil {
+		return log.Errore(err)
+	}
+	_, err = db.ExecDb(`
+			insert into process_audit
+				(audit_timestamp, process_key, trigger_type, user_name, source_ip, hostname, job_id, attempt,
+				params, exit_code, signal_name, error_message, duration_seconds, output_digest)
+			values
+				(now(), ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			`,
+		audit.ProcessKey, audit.Trigger, audit.User, audit.SourceIP, audit.Hostname, audit.JobId, audit.Attempt,
+		string(params), audit.ExitCode, audit.Signal, audit.ErrorMessage, audit.DurationSeconds, audit.OutputDigest,
+	)
+	return log.Errore(err)
+}
+
+// readAuditEntries reads audit entries matching given condition, most recent first. A zero limit reads all.
+func readAuditEntries(whereCondition string, args []interface{}, limit int, offset int) (entries [](*AuditEntry), err error) {
+	limitClause := ""
+	if limit > 0 {
+		limitClause = fmt.Sprintf("limit %d offset %d", limit, offset)
+	}
+	query := fmt.Sprintf(`
+		select
+			audit_id, audit_timestamp, process_key, trigger_type, user_name, source_ip, hostname, job_id, attempt,
+			ifnull(params, '') as params,
+			exit_code, signal_name,
+			ifnull(error_message, '') as error_message,
+			duration_seconds, output_digest
+		from
+			process_audit
+		%s
+		order by
+			audit_id desc
+		%s
+		`, whereCondition, limitClause)
+	err = db.QueryDB(query, args, func(m sqlutils.RowMap) error {
+		audit := &AuditEntry{
+			AuditId:        m.GetInt64("audit_id"),
+			AuditTimestamp: m.GetString("audit_timestamp"),
+			ProcessKey:     m.GetString("process_key"),
+			Trigger:        m.GetString("trigger_type"),
+			User:           m.GetString("user_name"),
+			SourceIP:       m.GetString("source_ip"),
+			Hostname:       m.GetString("hostname"),
+			JobId:          m.GetString("job_id"),
+			Attempt:        m.GetInt("attempt"),
+			ExitCode:       m.GetInt("exit_code"),
+			Signal:         m.GetString("signal_name"),
+			ErrorMessage:   m.GetString("error_message"),
+			OutputDigest:   m.GetString("output_digest"),
+		}
+		audit.DurationSeconds, _ = strconv.ParseFloat(m.GetString("duration_seconds"), 64)
+		if params := m.GetString("params"); params != "" {
+			if err := json.Unmarshal([]byte(params), &audit.Params); err != nil {
+				log.Errorf("cannot decode params of audit entry %d: %+v", audit.AuditId, err)
+			}
+		}
+		entries = append(entries, audit)
+		return nil
+	})
+	return entries, log.Errore(err)
+}
+
+// ReadAuditEntries returns a page of audit entries matching given filter, most recent first
+func ReadAuditEntries(filter *AuditFilter, page int) ([](*AuditEntry), error) {
+	conditions := []string{}
+	args := sqlutils.Args()
+	addCondition := func(condition string, value string) {
+		if value != "" {
+			conditions = append(conditions, condition)
+			args = append(args, value)
+		}
+	}
+	addCondition("process_key = ?", filter.ProcessKey)
+	addCondition("trigger_type = ?", filter.Trigger)
+	addCondition("user_name = ?", filter.User)
+	addCondition("source_ip = ?", filter.SourceIP)
+	addCondition("hostname = ?", filter.Hostname)
+	addCondition("job_id = ?", filter.JobId)
+	addCondition("audit_timestamp >= ?", filter.Since)
+	addCondition("audit_timestamp < ?", filter.Until)
+
+	whereCondition := ""
+	if len(conditions) > 0 {
+		whereCondition = "where " + strings.Join(conditions, " and ")
+	}
+	return readAuditEntries(whereCondition, args, config.Config().AuditPageSize, page*config.Config().AuditPageSize)
+}
+
+// ReadLastAuditEntries returns the most recent execution of each process, mapped by process key
+func ReadLastAuditEntries() (map[string]*AuditEntry, error) {
+	entries, err := readAuditEntries(`
+		where audit_id in (
+			select max(audit_id) from process_audit group by process_key
+		)`, sqlutils.Args(), 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	lastEntries := make(map[string]*AuditEntry)
+	for _, audit := range entries {
+		lastEntries[audit.ProcessKey] = audit
+	}
+	return lastEntries, nil
+}
+
+// ExpireAuditEntries purges audit entries older than AuditExpireHours. It is run by the active node.
+func ExpireAuditEntries() error {
+	_, err := db.ExecDb(`
+			delete
+				from process_audit
+			where
+				audit_timestamp < now() - interval ? hour
+			`,
+		config.Config().AuditExpireHours,
+	)
+	return log.Errore(err)
+}
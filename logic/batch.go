@@ -0,0 +1,145 @@
+package logic
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/github/my-manager/config"
+	"github.com/github/my-manager/util"
+)
+
+const (
+	BatchModeSequential = "sequential"
+	BatchModeParallel   = "parallel"
+)
+
+const (
+	BatchItemSucceeded = "succeeded"
+	BatchItemFailed    = "failed"
+	BatchItemSkipped   = "skipped"
+)
+
+// defaultBatchConcurrency applies to parallel batches which do not specify their concurrency
+const defaultBatchConcurrency = 4
+
+// BatchRequest is a list of process invocations, each formatted like a common request:
+// a "key" entry naming the process, and parameter values
+type BatchRequest struct {
+	Mode          string // "sequential" (default) or "parallel"
+	Concurrency   int    // Maximum invocations running at once in parallel mode, up to MaxBatchConcurrency
+	StopOnFailure bool   // When true, invocations not yet started once one fails are skipped
+	Invocations   []map[string]string
+}
+
+// BatchItemResult is the outcome of a single invocation in a batch
+type BatchItemResult struct {
+	Index  int
+	Key    string
+	Status string
+	Result *util.CommandResult `json:",omitempty"`
+	Error  string              `json:",omitempty"`
+}
+
+// BatchResult is the outcome of a batch, with items in request order
+type BatchResult struct {
+	Succeeded int
+	Failed    int
+	Skipped   int
+	Items     [](*BatchItemResult)
+}
+
+// RunBatch runs all invocations of a batch and waits for them to complete
+func RunBatch(request *BatchRequest, user string, sourceIP string) (*BatchResult, error) {
+	concurrency := 1
+	switch request.Mode {
+	case "", BatchModeSequential:
+	case BatchModeParallel:
+		concurrency = request.Concurrency
+		if concurrency < 0 || concurrency > config.Config().MaxBatchConcurrency {
+			return nil, fmt.Errorf("batch concurrency %d out of range; expected 1 to %d", concurrency, config.Config().MaxBatchConcurrency)
+		}
+		if concurrency == 0 {
+			concurrency = defaultBatchConcurrency
+			if concurrency > config.Config().MaxBatchConcurrency {
+				concurrency = config.Config().MaxBatchConcurrency
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown batch mode %q; expected %s or %s", request.Mode, BatchModeSequential, BatchModeParallel)
+	}
+	if len(request.Invocations) == 0 {
+		return nil, fmt.Errorf("batch has no invocations")
+	}
+	if len(request.Invocations) > config.Config().MaxBatchInvocations {
+		return nil, fmt.Errorf("batch has %d invocations; at most %d are allowed", len(request.Invocations), config.Config().MaxBatchInvocations)
+	}
+
+	batchResult := &BatchResult{}
+	semaphore := make(chan bool, concurrency)
+	var wg sync.WaitGroup
+	var failed int64
+	for i, invocation := range request.Invocations {
+		item := &BatchItemResult{Index: i, Key: invocation["key"]}
+		batchResult.Items = append(batchResult.Items, item)
+
+		semaphore <- true
+		if request.StopOnFailure && atomic.LoadInt64(&failed) > 0 {
+			item.Status = BatchItemSkipped
+			<-semaphore
+			continue
+		}
+		wg.Add(1)
+		go func(invocation map[string]string) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			runBatchItem(item, invocation, user, sourceIP)
+			if item.Status == BatchItemFailed {
+				atomic.AddInt64(&failed, 1)
+			}
+		}(invocation)
+	}
+	wg.Wait()
+
+	for _, item := range batchResult.Items {
+		switch item.Status {
+		case BatchItemSucceeded:
+			batchResult.Succeeded++
+		case BatchItemFailed:
+			batchResult.Failed++
+		case BatchItemSkipped:
+			batchResult.Skipped++
+		}
+	}
+	return batchResult, nil
+}
+
+// runBatchItem validates and synchronously runs a single invocation
+func runBatchItem(item *BatchItemResult, invocation map[string]string, user string, sourceIP string) {
+	item.Status = BatchItemFailed
+	if item.Key == "" {
+		item.Error = "invocation must add 'key' param"
+		return
+	}
+	if invocation["async"] == "1" {
+		item.Error = "async invocations are not supported in batches"
+		return
+	}
+	proc, found := GetProcess(item.Key)
+	if !found || len(proc.Script) == 0 {
+		item.Error = fmt.Sprintf("process not found: %s", item.Key)
+		return
+	}
+	command, err := NewProcessCommand(proc, invocation)
+	if err != nil {
+		item.Error = err.Error()
+		return
+	}
+	audit := NewAuditEntry(proc, AuditTriggerBatch, user, sourceIP, invocation)
+	item.Result, err = RunProcessCommand(proc, command, audit)
+	if err != nil {
+		item.Error = err.Error()
+		return
+	}
+	item.Status = BatchItemSucceeded
+}
@@ -0,0 +1,63 @@
+package logic
+
+import (
+	"github.com/github/my-manager/config"
+)
+
+const (
+	ProcessSourceConfig   = "config"
+	ProcessSourceRegistry = "registry"
+)
+
+// ProcessCatalogEntry describes a configured process to API callers: how to invoke it, when
+// it is scheduled and how it last ran
+type ProcessCatalogEntry struct {
+	Key             string
+	Source          string // Where the process is defined: "config" file or API managed "registry"
+	RegistryVersion uint64 `json:",omitempty"`
+	Description     string
+	Params          []config.ProcessParam
+	OutputCaptured  bool
+	OutputFormat    string
+	TimeoutSeconds  uint
+	Concurrency     *ProcessConcurrencyStatus `json:",omitempty"`
+	Schedule        *ScheduleStatus           `json:",omitempty"`
+	LastRun         *AuditEntry               `json:",omitempty"`
+	Script          string                    `json:",omitempty"`
+}
+
+// ProcessCatalog lists the configured and registered processes. Scripts are only included when includeScripts is set.
+func ProcessCatalog(includeScripts bool) ([](*ProcessCatalogEntry), error) {
+	lastRuns, err := ReadLastAuditEntries()
+	if err != nil {
+		return nil, err
+	}
+	registryVersions := make(map[*config.Process]uint64)
+	for _, registered := range ReadProcessRegistry().Processes {
+		registryVersions[registered.Process] = registered.Version
+	}
+	catalog := [](*ProcessCatalogEntry){}
+	for _, proc := range AllProcesses() {
+		entry := &ProcessCatalogEntry{
+			Key:            proc.Key,
+			Source:         ProcessSourceConfig,
+			Description:    proc.Description,
+			Params:         proc.Params,
+			OutputCaptured: proc.IsOutputCaptured(),
+			OutputFormat:   proc.OutputFormat,
+			TimeoutSeconds: uint(ProcessTimeout(proc).Seconds()),
+			Concurrency:    processConcurrencyStatus(proc),
+			Schedule:       scheduledProcessStatus(proc.Key),
+			LastRun:        lastRuns[proc.Key],
+		}
+		if version, registered := registryVersions[proc]; registered {
+			entry.Source = ProcessSourceRegistry
+			entry.RegistryVersion = version
+		}
+		if includeScripts {
+			entry.Script = proc.Script
+		}
+		catalog = append(catalog, entry)
+	}
+	return catalog, nil
+}
@@ -0,0 +1,256 @@
+package logic
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/github/my-manager/config"
+	"github.com/github/my-manager/process"
+	"github.com/github/my-manager/raft"
+	"github.com/github/my-manager/util"
+
+	"github.com/openark/golib/log"
+)
+
+const (
+	AcquireClusterLockCommand = "acquire-cluster-lock"
+	ReleaseClusterLockCommand = "release-cluster-lock"
+)
+
+const defaultClusterLockLeaseSeconds = 30
+
+// ClusterLock is a lease based lock replicated through raft. A holder which stops renewing
+// its lease, e.g. because it crashed, loses the lock once the lease expires.
+type ClusterLock struct {
+	Name       string
+	Owner      string // Hostname of the node holding the lock
+	HolderId   string
+	AcquiredAt time.Time
+	ExpiresAt  time.Time
+}
+
+// ClusterLockError indicates a cluster lock is held by someone else
+type ClusterLockError struct {
+	Lock *ClusterLock
+}
+
+func (this *ClusterLockError) Error() string {
+	return fmt.Sprintf("cluster lock %s is held by %s until %s", this.Lock.Name, this.Lock.Owner, this.Lock.ExpiresAt.Format(time.RFC3339))
+}
+
+// IsClusterLockError returns true when given error indicates a cluster lock is held by someone else
+func IsClusterLockError(err error) bool {
+	_, ok := err.(*ClusterLockError)
+	return ok
+}
+
+// clusterLockCommand is the payload of acquire-cluster-lock and release-cluster-lock commands.
+// Timestamp is set by the leader publishing the command, so that all nodes evaluate leases alike.
+type clusterLockCommand struct {
+	Name         string
+	Owner        string
+	HolderId     string
+	LeaseSeconds uint
+	Timestamp    time.Time
+}
+
+var clusterLocks = make(map[string]*ClusterLock)
+var clusterLocksMutex sync.RWMutex
+
+// ReadClusterLocks returns the cluster locks as applied on this node whose lease has not expired
+func ReadClusterLocks() [](*ClusterLock) {
+	clusterLocksMutex.RLock()
+	defer clusterLocksMutex.RUnlock()
+
+	now := time.Now()
+	locks := [](*ClusterLock){}
+	for _, lock := range clusterLocks {
+		if lock.ExpiresAt.After(now) {
+			lockCopy := *lock
+			locks = append(locks, &lockCopy)
+		}
+	}
+	sort.SliceStable(locks, func(i, j int) bool {
+		return locks[i].Name < locks[j].Name
+	})
+	return locks
+}
+
+// PublishAcquireClusterLock acquires or renews a cluster lock. It must run on the leader.
+func PublishAcquireClusterLock(name string, owner string, holderId string, leaseSeconds uint) (*ClusterLock, error) {
+	command := &clusterLockCommand{Name: name, Owner: owner, HolderId: holderId, LeaseSeconds: leaseSeconds, Timestamp: time.Now()}
+	response, err := oraft.PublishCommand(AcquireClusterLockCommand, command)
+	if err != nil {
+		return nil, err
+	}
+	lock, _ := response.(*ClusterLock)
+	return lock, nil
+}
+
+// PublishReleaseClusterLock releases a cluster lock. It must run on the leader.
+func PublishReleaseClusterLock(name string, holderId string) error {
+	command := &clusterLockCommand{Name: name, HolderId: holderId, Timestamp: time.Now()}
+	_, err := oraft.PublishCommand(ReleaseClusterLockCommand, command)
+	return err
+}
+
+// acquireClusterLock acquires or renews a cluster lock on behalf of this node, asking the
+// leader to do so when this node is a follower
+func acquireClusterLock(name string, holderId string, leaseSeconds uint) error {
+	if !oraft.IsRaftEnabled() {
+		return fmt.Errorf("cluster lock %s requires raft", name)
+	}
+	if oraft.IsLeader() {
+		_, err := PublishAcquireClusterLock(name, process.ThisHostname, holderId, leaseSeconds)
+		return err
+	}
+	body, err := oraft.HttpGetLeader(fmt.Sprintf("acquire-cluster-lock/%s/%s/%s/%d", url.PathEscape(name), url.PathEscape(process.ThisHostname), holderId, leaseSeconds))
+	if err != nil {
+		response := &struct {
+			Details *ClusterLock
+		}{}
+		if json.Unmarshal(body, response) == nil && response.Details != nil {
+			return &ClusterLockError{Lock: response.Details}
+		}
+		return err
+	}
+	return nil
+}
+
+// releaseClusterLock releases a cluster lock held by this node
+func releaseClusterLock(name string, holderId string) error {
+	if oraft.IsLeader() {
+		return PublishReleaseClusterLock(name, holderId)
+	}
+	_, err := oraft.HttpGetLeader(fmt.Sprintf("release-cluster-lock/%s/%s", url.PathEscape(name), holderId))
+	return err
+}
+
+// clusterLockRenewInterval returns the time to wait before renewing a lease: a third of the
+// lease, less up to a quarter of that as jitter, so that holders do not renew in lockstep
+func clusterLockRenewInterval(lease time.Duration) time.Duration {
+	retryJitterRandMutex.Lock()
+	defer retryJitterRandMutex.Unlock()
+	return lease/3 - time.Duration(retryJitterRand.Float64()*float64(lease/12))
+}
+
+// holdClusterLock takes the cluster lock of a process requiring one, and keeps renewing its
+// lease until the returned function is called. Should a renewal fail, the lock can no longer be
+// trusted to be held, and onLost is called.
+func holdClusterLock(proc *config.Process, onLost func()) (release func(), err error) {
+	if !proc.ClusterLock {
+		return func() {}, nil
+	}
+	name := fmt.Sprintf("process:%s", proc.Key)
+	holderId := util.NewToken().Hash
+	leaseSeconds := util.ConvStrToUInt(proc.ClusterLockLeaseSeconds)
+	if leaseSeconds == 0 {
+		leaseSeconds = defaultClusterLockLeaseSeconds
+	}
+	lease := time.Duration(leaseSeconds) * time.Second
+	if err := acquireClusterLock(name, holderId, leaseSeconds); err != nil {
+		return nil, err
+	}
+
+	done := make(chan bool)
+	go func() {
+		timer := time.NewTimer(clusterLockRenewInterval(lease))
+		defer timer.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-timer.C:
+				if err := acquireClusterLock(name, holderId, leaseSeconds); err != nil {
+					log.Errorf("cannot renew cluster lock %s: %+v; canceling run of %s", name, err, proc.Key)
+					onLost()
+					return
+				}
+				timer.Reset(clusterLockRenewInterval(lease))
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		if err := releaseClusterLock(name, holderId); err != nil {
+			log.Errorf("cannot release cluster lock %s; it will expire within %+v: %+v", name, lease, err)
+		}
+	}, nil
+}
+
+// expireClusterLocks forgets locks whose lease expired by given time. Must be called with the mutex held.
+func expireClusterLocks(now time.Time) {
+	for name, lock := range clusterLocks {
+		if !lock.ExpiresAt.After(now) {
+			delete(clusterLocks, name)
+		}
+	}
+}
+
+// applyAcquireClusterLock is invoked by the FSM on all nodes
+func applyAcquireClusterLock(value []byte) interface{} {
+	var command clusterLockCommand
+	if err := json.Unmarshal(value, &command); err != nil {
+		return log.Errore(err)
+	}
+	clusterLocksMutex.Lock()
+	defer clusterLocksMutex.Unlock()
+
+	expireClusterLocks(command.Timestamp)
+	lock, found := clusterLocks[command.Name]
+	if found && lock.HolderId != command.HolderId {
+		lockCopy := *lock
+		return &ClusterLockError{Lock: &lockCopy}
+	}
+	if !found {
+		lock = &ClusterLock{Name: command.Name, Owner: command.Owner, HolderId: command.HolderId, AcquiredAt: command.Timestamp}
+		clusterLocks[command.Name] = lock
+	}
+	lock.ExpiresAt = command.Timestamp.Add(time.Duration(command.LeaseSeconds) * time.Second)
+	lockCopy := *lock
+	return &lockCopy
+}
+
+// applyReleaseClusterLock is invoked by the FSM on all nodes
+func applyReleaseClusterLock(value []byte) interface{} {
+	var command clusterLockCommand
+	if err := json.Unmarshal(value, &command); err != nil {
+		return log.Errore(err)
+	}
+	clusterLocksMutex.Lock()
+	defer clusterLocksMutex.Unlock()
+
+	expireClusterLocks(command.Timestamp)
+	if lock, found := clusterLocks[command.Name]; found && lock.HolderId == command.HolderId {
+		delete(clusterLocks, command.Name)
+	}
+	return nil
+}
+
+// snapshotClusterLocks returns all cluster locks, for raft snapshots
+func snapshotClusterLocks() [](*ClusterLock) {
+	clusterLocksMutex.RLock()
+	defer clusterLocksMutex.RUnlock()
+
+	locks := [](*ClusterLock){}
+	for _, lock := range clusterLocks {
+		lockCopy := *lock
+		locks = append(locks, &lockCopy)
+	}
+	return locks
+}
+
+// restoreClusterLocks replaces the cluster locks with those read from a raft snapshot
+func restoreClusterLocks(locks [](*ClusterLock)) {
+	clusterLocksMutex.Lock()
+	defer clusterLocksMutex.Unlock()
+
+	clusterLocks = make(map[string]*ClusterLock)
+	for _, lock := range locks {
+		clusterLocks[lock.Name] = lock
+	}
+}
@@ -0,0 +1,66 @@
+package logic
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func applyClusterLockCommand(t *testing.T, op string, command clusterLockCommand) interface{} {
+	value, err := json.Marshal(command)
+	if err != nil {
+		t.Fatalf("cannot marshal command: %+v", err)
+	}
+	if op == ReleaseClusterLockCommand {
+		return applyReleaseClusterLock(value)
+	}
+	return applyAcquireClusterLock(value)
+}
+
+func TestApplyClusterLockCommands(t *testing.T) {
+	restoreClusterLocks(nil)
+	t0 := time.Date(2026, time.January, 14, 10, 0, 0, 0, time.UTC)
+	acquire := func(holderId string, at time.Duration) interface{} {
+		return applyClusterLockCommand(t, AcquireClusterLockCommand, clusterLockCommand{Name: "process:p", Owner: "host-" + holderId, HolderId: holderId, LeaseSeconds: 30, Timestamp: t0.Add(at)})
+	}
+
+	lock, ok := acquire("a", 0).(*ClusterLock)
+	if !ok || lock.HolderId != "a" || !lock.ExpiresAt.Equal(t0.Add(30*time.Second)) {
+		t.Fatalf("expected the lock to be acquired by a until 30s, got %+v", lock)
+	}
+	if err, ok := acquire("b", 10*time.Second).(*ClusterLockError); !ok || err.Lock.HolderId != "a" {
+		t.Fatalf("expected the lock to be held by a, got %+v", err)
+	}
+	// Renewing extends the lease, keeping the time the lock was acquired at
+	lock, ok = acquire("a", 20*time.Second).(*ClusterLock)
+	if !ok || !lock.AcquiredAt.Equal(t0) || !lock.ExpiresAt.Equal(t0.Add(50*time.Second)) {
+		t.Fatalf("expected the lease renewed until 50s, got %+v", lock)
+	}
+	if _, ok := acquire("b", 49*time.Second).(*ClusterLockError); !ok {
+		t.Fatalf("expected the renewed lease to hold at 49s")
+	}
+	// Once the lease expired, another holder may take the lock
+	lock, ok = acquire("b", 50*time.Second).(*ClusterLock)
+	if !ok || lock.HolderId != "b" || !lock.AcquiredAt.Equal(t0.Add(50*time.Second)) {
+		t.Fatalf("expected the lock to be acquired by b at 50s, got %+v", lock)
+	}
+
+	// Only the holder releases the lock
+	applyClusterLockCommand(t, ReleaseClusterLockCommand, clusterLockCommand{Name: "process:p", HolderId: "a", Timestamp: t0.Add(55 * time.Second)})
+	if locks := snapshotClusterLocks(); len(locks) != 1 || locks[0].HolderId != "b" {
+		t.Fatalf("expected the lock still held by b, got %+v", locks)
+	}
+	applyClusterLockCommand(t, ReleaseClusterLockCommand, clusterLockCommand{Name: "process:p", HolderId: "b", Timestamp: t0.Add(56 * time.Second)})
+	if locks := snapshotClusterLocks(); len(locks) != 0 {
+		t.Fatalf("expected no lock once released, got %+v", locks)
+	}
+}
+
+func TestClusterLockRenewInterval(t *testing.T) {
+	lease := 30 * time.Second
+	for i := 0; i < 100; i++ {
+		if interval := clusterLockRenewInterval(lease); interval > 10*time.Second || interval < 10*time.Second-lease/12 {
+			t.Fatalf("expected a renewal within a third of the lease, less a quarter of that, got %+v", interval)
+		}
+	}
+}
@@ -17,23 +17,42 @@ func NewCommandApplier() *CommandApplier {
 	return applier
 }
 
-func (applier *CommandApplier) ApplyCommand(op string, value []byte) interface{} {
+func (applier *CommandApplier) ApplyCommand(op string, value []byte, index uint64) interface{} {
+	defer watches.onApplied(index)
+
 	switch op {
 	case "heartbeat":
 		return nil
 	case "leader-uri":
-		return applier.leaderURI(value)
+		return applier.leaderURI(value, index)
 	case "request-health-report":
 		return applier.healthReport(value)
+	case RegisterProcessCommand:
+		return applyRegisterProcess(value, index)
+	case UnregisterProcessCommand:
+		return applyUnregisterProcess(value, index)
+	case AcquireClusterLockCommand:
+		return applyAcquireClusterLock(value)
+	case ReleaseClusterLockCommand:
+		return applyReleaseClusterLock(value)
+	case KVPutCommand:
+		return applyKVPut(value, index)
+	case KVDeleteCommand:
+		return applyKVDelete(value, index)
+	case KVExpireCommand:
+		return applyKVExpire(value, index)
 	}
 	return log.Errorf("Unknown command op: %s", op)
 }
 
-func (applier *CommandApplier) leaderURI(value []byte) interface{} {
+func (applier *CommandApplier) leaderURI(value []byte, index uint64) interface{} {
 	var uri string
 	if err := json.Unmarshal(value, &uri); err != nil {
 		return log.Errore(err)
 	}
+	if uri != oraft.LeaderURI.Get() {
+		watches.leaderURIChanged(index)
+	}
 	oraft.LeaderURI.Set(uri)
 	return nil
 }
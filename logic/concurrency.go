@@ -0,0 +1,247 @@
+package logic
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/github/my-manager/config"
+	"github.com/github/my-manager/util"
+)
+
+// defaultQueueTimeout bounds the wait of queued runs of processes which do not set queueTimeoutSeconds
+const defaultQueueTimeout = 60 * time.Second
+
+// ConcurrencyLimitError indicates a run was rejected because its process is at its concurrency limit
+type ConcurrencyLimitError struct {
+	Key    string
+	Reason string
+}
+
+func (this *ConcurrencyLimitError) Error() string {
+	return fmt.Sprintf("process %s is at its concurrency limit: %s", this.Key, this.Reason)
+}
+
+// IsConcurrencyLimitError returns true when given error indicates a run was rejected for
+// exceeding its process concurrency limit
+func IsConcurrencyLimitError(err error) bool {
+	_, ok := err.(*ConcurrencyLimitError)
+	return ok
+}
+
+// ProcessConcurrencyStatus describes the usage of a process' concurrency limit on this node
+type ProcessConcurrencyStatus struct {
+	Key            string
+	MaxConcurrency int
+	MaxQueued      int
+	Running        int
+	Queued         int
+}
+
+// processLimiter bounds the simultaneous runs of a process, holding excess runs in a bounded queue.
+// It tracks runs even while the process is unlimited, so that limits changed by a reload
+// apply to the runs already holding a slot.
+type processLimiter struct {
+	key string
+
+	mutex          sync.Mutex
+	maxConcurrency int // 0 for unlimited
+	maxQueued      int
+	queueTimeout   time.Duration
+	running        int
+	queued         int
+	freed          chan bool // Closed, and replaced, whenever a slot may have become free
+}
+
+var processLimiters = make(map[string]*processLimiter)
+var processLimitersMutex sync.Mutex
+
+func newProcessLimiter(key string) *processLimiter {
+	return &processLimiter{key: key, freed: make(chan bool)}
+}
+
+// getProcessLimiter returns the limiter of given process, or nil when its concurrency is unlimited
+// and no run is tracked. An existing limiter is resized in place when the process limits
+// changed: runs holding a slot keep counting against the new limits.
+func getProcessLimiter(proc *config.Process) *processLimiter {
+	processLimitersMutex.Lock()
+	defer processLimitersMutex.Unlock()
+
+	limiter, found := processLimiters[proc.Key]
+	if !found {
+		if util.ConvStrToUInt(proc.MaxConcurrency) == 0 {
+			return nil
+		}
+		limiter = newProcessLimiter(proc.Key)
+		processLimiters[proc.Key] = limiter
+	}
+	limiter.resize(proc)
+	return limiter
+}
+
+// lookupProcessLimiter returns the existing limiter of given key, if any, without creating one
+func lookupProcessLimiter(key string) *processLimiter {
+	processLimitersMutex.Lock()
+	defer processLimitersMutex.Unlock()
+
+	return processLimiters[key]
+}
+
+// resize applies the current limits of the process, letting queued runs in should it allow more
+func (limiter *processLimiter) resize(proc *config.Process) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	maxConcurrency := int(util.ConvStrToUInt(proc.MaxConcurrency))
+	maxQueued := int(util.ConvStrToUInt(proc.MaxQueued))
+	queueTimeout := time.Duration(util.ConvStrToUInt(proc.QueueTimeoutSeconds)) * time.Second
+	if queueTimeout == 0 {
+		queueTimeout = defaultQueueTimeout
+	}
+	if maxConcurrency == limiter.maxConcurrency && maxQueued == limiter.maxQueued && queueTimeout == limiter.queueTimeout {
+		return
+	}
+	limiter.maxConcurrency, limiter.maxQueued, limiter.queueTimeout = maxConcurrency, maxQueued, queueTimeout
+	limiter.notifyFreed()
+}
+
+// notifyFreed wakes up queued runs. Must be called with the mutex held.
+func (limiter *processLimiter) notifyFreed() {
+	close(limiter.freed)
+	limiter.freed = make(chan bool)
+}
+
+// takeSlot takes a slot if one is free. Must be called with the mutex held.
+func (limiter *processLimiter) takeSlot() bool {
+	if limiter.maxConcurrency > 0 && limiter.running >= limiter.maxConcurrency {
+		return false
+	}
+	limiter.running++
+	return true
+}
+
+// enter takes a free slot, or else a place in the queue. It returns true if a slot was taken,
+// false if the caller must wait(), or an error if the queue is full.
+func (limiter *processLimiter) enter() (acquired bool, err error) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	if limiter.takeSlot() {
+		return true, nil
+	}
+	if limiter.queued >= limiter.maxQueued {
+		return false, &ConcurrencyLimitError{Key: limiter.key, Reason: fmt.Sprintf("%d running and %d queued", limiter.running, limiter.queued)}
+	}
+	limiter.queued++
+	return false, nil
+}
+
+// wait gives up the place in the queue taken by enter() for a slot, waiting at most the queue
+// timeout, and no longer than ctx lasts
+func (limiter *processLimiter) wait(ctx context.Context) error {
+	limiter.mutex.Lock()
+	timer := time.NewTimer(limiter.queueTimeout)
+	defer timer.Stop()
+	for {
+		if limiter.takeSlot() {
+			limiter.queued--
+			limiter.mutex.Unlock()
+			return nil
+		}
+		freed := limiter.freed
+		limiter.mutex.Unlock()
+
+		var err error
+		select {
+		case <-freed:
+		case <-timer.C:
+			err = &ConcurrencyLimitError{Key: limiter.key, Reason: fmt.Sprintf("no slot freed up within %+v", limiter.queueTimeout)}
+		case <-ctx.Done():
+			err = util.ErrCommandCanceled
+		}
+		limiter.mutex.Lock()
+		if err != nil {
+			limiter.queued--
+			limiter.mutex.Unlock()
+			return err
+		}
+	}
+}
+
+// release frees a slot taken by enter() or wait()
+func (limiter *processLimiter) release() {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	limiter.running--
+	limiter.notifyFreed()
+}
+
+// status returns the usage of the limiter against given process limits
+func (limiter *processLimiter) status(proc *config.Process) *ProcessConcurrencyStatus {
+	status := &ProcessConcurrencyStatus{
+		Key:            proc.Key,
+		MaxConcurrency: int(util.ConvStrToUInt(proc.MaxConcurrency)),
+		MaxQueued:      int(util.ConvStrToUInt(proc.MaxQueued)),
+	}
+	if limiter != nil {
+		limiter.mutex.Lock()
+		defer limiter.mutex.Unlock()
+		status.Running, status.Queued = limiter.running, limiter.queued
+	}
+	return status
+}
+
+// reserveProcessSlot takes a free slot of the process, or a place in its queue, failing right
+// away when the queue is full. The returned wait function blocks until a slot is held, or the
+// given context is done; once it succeeded, release must be called when the run completes.
+func reserveProcessSlot(proc *config.Process) (wait func(ctx context.Context) error, release func(), err error) {
+	limiter := getProcessLimiter(proc)
+	if limiter == nil {
+		return func(ctx context.Context) error { return nil }, func() {}, nil
+	}
+	acquired, err := limiter.enter()
+	if err != nil {
+		return nil, nil, err
+	}
+	if acquired {
+		return func(ctx context.Context) error { return nil }, limiter.release, nil
+	}
+	return limiter.wait, limiter.release, nil
+}
+
+// acquireProcessSlot waits for the process to be below its concurrency limit, or for ctx to be
+// done. The returned function must be called once the run completes.
+func acquireProcessSlot(ctx context.Context, proc *config.Process) (release func(), err error) {
+	wait, release, err := reserveProcessSlot(proc)
+	if err != nil {
+		return nil, err
+	}
+	if err := wait(ctx); err != nil {
+		return nil, err
+	}
+	return release, nil
+}
+
+// ProcessConcurrency lists the concurrency usage of all processes declaring a limit
+func ProcessConcurrency() (statuses [](*ProcessConcurrencyStatus)) {
+	for _, proc := range AllProcesses() {
+		if status := processConcurrencyStatus(proc); status != nil {
+			statuses = append(statuses, status)
+		}
+	}
+	sort.SliceStable(statuses, func(i, j int) bool {
+		return statuses[i].Key < statuses[j].Key
+	})
+	return statuses
+}
+
+// processConcurrencyStatus returns the concurrency usage of given process, or nil when it is
+// unlimited. It only looks limiters up: the limits shown are the declared ones, which runs
+// apply to the limiter as they start.
+func processConcurrencyStatus(proc *config.Process) *ProcessConcurrencyStatus {
+	if util.ConvStrToUInt(proc.MaxConcurrency) == 0 {
+		return nil
+	}
+	return lookupProcessLimiter(proc.Key).status(proc)
+}
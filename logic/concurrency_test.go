@@ -0,0 +1,119 @@
+package logic
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/github/my-manager/config"
+)
+
+func limitedProcess(key string, maxConcurrency string, maxQueued string) *config.Process {
+	return &config.Process{Key: key, MaxConcurrency: maxConcurrency, MaxQueued: maxQueued, QueueTimeoutSeconds: "5"}
+}
+
+func TestProcessLimiterResizeKeepsHolders(t *testing.T) {
+	proc := limitedProcess("resized", "2", "0")
+	var releases []func()
+	for i := 0; i < 2; i++ {
+		release, err := acquireProcessSlot(context.Background(), proc)
+		if err != nil {
+			t.Fatalf("run %d: unexpected error: %+v", i, err)
+		}
+		releases = append(releases, release)
+	}
+	if _, err := acquireProcessSlot(context.Background(), proc); !IsConcurrencyLimitError(err) {
+		t.Fatalf("expected the limit of 2 to be reached, got %+v", err)
+	}
+
+	// A reload raising the limit to 3 leaves room for one more run, not three
+	reloaded := limitedProcess("resized", "3", "0")
+	release, err := acquireProcessSlot(context.Background(), reloaded)
+	if err != nil {
+		t.Fatalf("unexpected error after raising the limit: %+v", err)
+	}
+	releases = append(releases, release)
+	if _, err := acquireProcessSlot(context.Background(), reloaded); !IsConcurrencyLimitError(err) {
+		t.Fatalf("expected the limit of 3 to be reached, got %+v", err)
+	}
+	if status := processConcurrencyStatus(reloaded); status.Running != 3 || status.MaxConcurrency != 3 {
+		t.Errorf("expected 3 runs of at most 3, got %+v", status)
+	}
+
+	// Lowering it to 1 admits no run until the holders are down to none
+	lowered := limitedProcess("resized", "1", "0")
+	releases[0]()
+	releases[1]()
+	if _, err := acquireProcessSlot(context.Background(), lowered); !IsConcurrencyLimitError(err) {
+		t.Fatalf("expected the limit of 1 to be exceeded by the remaining run, got %+v", err)
+	}
+	releases[2]()
+	release, err = acquireProcessSlot(context.Background(), lowered)
+	if err != nil {
+		t.Fatalf("unexpected error once all runs completed: %+v", err)
+	}
+	release()
+}
+
+func TestProcessLimiterQueue(t *testing.T) {
+	proc := limitedProcess("queued", "1", "1")
+	release, err := acquireProcessSlot(context.Background(), proc)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	acquired := make(chan error)
+	go func() {
+		release, err := acquireProcessSlot(context.Background(), proc)
+		if err == nil {
+			release()
+		}
+		acquired <- err
+	}()
+	for processConcurrencyStatus(proc).Queued != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := acquireProcessSlot(context.Background(), proc); !IsConcurrencyLimitError(err) {
+		t.Errorf("expected a full queue, got %+v", err)
+	}
+	release()
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Errorf("queued run: unexpected error: %+v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("queued run did not get the freed slot")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	release, _ = acquireProcessSlot(context.Background(), proc)
+	go func() {
+		_, err := acquireProcessSlot(ctx, proc)
+		acquired <- err
+	}()
+	for processConcurrencyStatus(proc).Queued != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-acquired; err == nil {
+		t.Errorf("expected a canceled queued run to fail")
+	}
+	if status := processConcurrencyStatus(proc); status.Running != 1 || status.Queued != 0 {
+		t.Errorf("expected 1 run and an empty queue, got %+v", status)
+	}
+	release()
+}
+
+func TestProcessConcurrencyStatusDoesNotCreateLimiters(t *testing.T) {
+	proc := limitedProcess("looked-up", "4", "2")
+	status := processConcurrencyStatus(proc)
+	if status == nil || status.MaxConcurrency != 4 || status.MaxQueued != 2 || status.Running != 0 {
+		t.Errorf("unexpected status: %+v", status)
+	}
+	if lookupProcessLimiter(proc.Key) != nil {
+		t.Errorf("expected no limiter to be created by a status lookup")
+	}
+	if processConcurrencyStatus(limitedProcess("unlimited", "", "")) != nil {
+		t.Errorf("expected no status for an unlimited process")
+	}
+}
@@ -1,15 +1,18 @@
 package logic
 
 import (
+	"os"
+	"os/signal"
 	"strings"
 	"sync/atomic"
+	"syscall"
 	"time"
 
-	"github.com/openark/golib/log"
 	"github.com/github/my-manager/config"
 	"github.com/github/my-manager/process"
 	"github.com/github/my-manager/raft"
 	"github.com/github/my-manager/util"
+	"github.com/openark/golib/log"
 )
 
 const (
@@ -35,8 +38,8 @@ func IsLeaderOrActive() bool {
 
 func RaftNodesStatusCheck() {
 	var info string
-	nodeInfo := config.Config.RaftBind + config.Config.ListenAddress + " " + "ApiEndpoint" + ":" + config.Config.ApiEndpoint
-	alertApi := config.Config.RaftNodesStatusAlertProcess
+	nodeInfo := config.Config().RaftBind + config.Config().ListenAddress + " " + "ApiEndpoint" + ":" + config.Config().ApiEndpoint
+	alertApi := config.Config().RaftNodesStatusAlertProcess
 	if alertApi == "" {
 		nodeInfo = nodeInfo + " " + "RaftNodesStatusAlertProcess is null"
 		log.Error(nodeInfo)
@@ -64,7 +67,7 @@ func RaftNodesStatusCheck() {
 		}
 		return
 	}
-	if len(health.AvailableNodes) != len(config.Config.RaftNodes) {
+	if len(health.AvailableNodes) != len(config.Config().RaftNodes) {
 		info = "raft cluster AvailableNodes is less than RaftNodes"
 		info = nodeInfo + " " + info
 		alertApi = strings.Replace(alertApi, "{msg}", info, -1)
@@ -82,10 +85,10 @@ func LeaderDomainCheck() error {
 	var lookupIp []string
 	var err error
 
-	if config.Config.RaftLeaderDomain == "" {
+	if config.Config().RaftLeaderDomain == "" {
 		return nil
 	} else {
-		if len(config.Config.SwithDomainProcess) == 0 {
+		if len(config.Config().SwithDomainProcess) == 0 {
 			log.Errorf("RaftLeaderDomain is set but SwithDomainProcess is null")
 			return nil
 		}
@@ -98,11 +101,11 @@ func LeaderDomainCheck() error {
 	}
 
 	runAddDomain := func() error {
-                for _, value := range config.Config.SwithDomainProcess {
+		for _, value := range config.Config().SwithDomainProcess {
 			if value == "" {
 				continue
 			}
-			value = strings.Replace(value, "{domain}", config.Config.RaftLeaderDomain, -1)
+			value = strings.Replace(value, "{domain}", config.Config().RaftLeaderDomain, -1)
 			value = strings.Replace(value, "{ip}", localIp, -1)
 			_, err := util.RunCommandOutput(value)
 			if err != nil {
@@ -113,7 +116,7 @@ func LeaderDomainCheck() error {
 		return nil
 	}
 
-	lookupIp, err = util.LookupHost(config.Config.RaftLeaderDomain)
+	lookupIp, err = util.LookupHost(config.Config().RaftLeaderDomain)
 	if err != nil || len(lookupIp) == 0 {
 		if er := runAddDomain(); er != nil {
 			return er
@@ -131,40 +134,29 @@ func LeaderDomainCheck() error {
 	return nil
 }
 
-type OutScripts struct {
-	Url                string
-	Method             string
-	Key                string
-	Param              string
-	RunIntervalSeconds string
-	OutputFlag         string
-	Script             string
-	TickTime           <-chan time.Time
+// ReloadConfiguration re-reads the configuration files and applies the new configuration:
+// Processes, alert and domain settings take effect immediately, and scheduled processes
+// are restarted where their definition changed.
+func ReloadConfiguration() error {
+	if err := config.Reload(); err != nil {
+		return err
+	}
+	rescheduleProcesses()
+	return nil
 }
 
-func RunOutScript(outscript *OutScripts) {
-	for _ = range outscript.TickTime {
-		runFun := func() {
-			if outscript.OutputFlag == "0" {
-				err := util.RunCommandNoOutput(outscript.Script)
-				if err != nil {
-					log.Errorf("run cmd %s failed: %s", outscript.Script, err.Error())
-				}
-			} else {
-				_, err := util.RunCommandOutput(outscript.Script)
-				if err != nil {
-					log.Errorf("run cmd %s failed: %s", outscript.Script, err.Error())
-				}
+// acceptSignals reloads configuration upon SIGHUP
+func acceptSignals() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	go func() {
+		for range c {
+			log.Infof("Received SIGHUP. Reloading configuration")
+			if err := ReloadConfiguration(); err != nil {
+				log.Errorf("Configuration not reloaded: %+v", err)
 			}
 		}
-		if oraft.IsRaftEnabled() {
-			if oraft.IsLeader() {
-				runFun()
-			}
-		} else {
-			runFun()
-		}
-	}
+	}()
 }
 
 // ContinuousOperation starts an asynchronuous infinite discovery process where instances are
@@ -172,41 +164,23 @@ func RunOutScript(outscript *OutScripts) {
 func ContinuousOperation() {
 	log.Infof("continuous operation: setting up")
 
-	//run outSideScripts from config
-	{
-		scripts := config.Config.Processes
-		outProcessScripts := make([]*OutScripts, 0)
-		for _, vmap := range scripts {
-			if vmap["runIntervalSeconds"] != "" {
-				runIntervalSeconds := util.ConvStrToUInt(vmap["runIntervalSeconds"])
-				if runIntervalSeconds == 0 {
-					runIntervalSeconds = 60
-				}
-				outScripts := &OutScripts{
-					RunIntervalSeconds: vmap["runIntervalSeconds"],
-					Script:             vmap["script"],
-					OutputFlag:         vmap["outputFlag"],
-					TickTime:           time.Tick(time.Duration(runIntervalSeconds) * time.Second),
-				}
-				outProcessScripts = append(outProcessScripts, outScripts)
-			}
-		}
-		for _, script := range outProcessScripts {
-			go RunOutScript(script)
-		}
-	}
-
 	healthTick := time.Tick(config.HealthPollSeconds * time.Second)
-	domainCheckTick := time.Tick(time.Duration(config.Config.DomainCheckIntervalSeconds) * time.Second)
+	domainCheckTick := time.Tick(time.Duration(config.Config().DomainCheckIntervalSeconds) * time.Second)
 	caretakingTick := time.Tick(time.Minute)
-	raftNodesStatusCheckTick := time.Tick(time.Duration(config.Config.RaftNodesStatusCheckIntervalSeconds) * time.Second)
+	raftNodesStatusCheckTick := time.Tick(time.Duration(config.Config().RaftNodesStatusCheckIntervalSeconds) * time.Second)
 
-	if config.Config.RaftEnabled {
+	if config.Config().RaftEnabled {
 		if err := oraft.Setup(NewCommandApplier(), NewSnapshotDataCreatorApplier(), process.ThisHostname); err != nil {
 			log.Fatale(err)
 		}
 		go oraft.Monitor()
 	}
+	// Scheduled processes only run on the raft leader, hence once raft is set up
+	rescheduleProcesses()
+	acceptSignals()
+
+	go FailAbandonedJobs()
+	go ResumeWebhookDeliveries()
 
 	log.Infof("continuous operation: starting")
 	for {
@@ -215,6 +189,9 @@ func ContinuousOperation() {
 			go func() {
 				onHealthTick()
 			}()
+			if oraft.IsLeader() {
+				go ExpireKVEntries()
+			}
 		case <-domainCheckTick:
 			LeaderDomainCheck()
 			if oraft.IsLeader() {
@@ -225,6 +202,9 @@ func ContinuousOperation() {
 			if oraft.IsLeader() {
 				go process.ExpireNodesHistory()
 				go process.ExpireAvailableNodes()
+				go ExpireJobs()
+				go ExpireAuditEntries()
+				go ExpireWebhookDeliveries()
 			}
 		case <-raftNodesStatusCheckTick:
 			if oraft.IsRaftEnabled() {
@@ -0,0 +1,191 @@
+package logic
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/github/my-manager/config"
+	"github.com/github/my-manager/process"
+	"github.com/github/my-manager/util"
+
+	"github.com/openark/golib/log"
+)
+
+const (
+	JobStatusQueued    = "queued"
+	JobStatusRunning   = "running"
+	JobStatusSucceeded = "succeeded"
+	JobStatusFailed    = "failed"
+)
+
+// Job represents an asynchronous execution of a configured process, as recorded
+// in the process_job table
+type Job struct {
+	JobId           string
+	ProcessKey      string
+	Hostname        string
+	Token           string `json:"-"`          // Identifies the process instance running the job; not exposed
+	CallbackURL     string `json:",omitempty"` // Notified once the job finishes
+	Status          string
+	ExitCode        int
+	Signal          string
+	Stdout          string
+	Stderr          string
+	StdoutJSON      interface{} `json:",omitempty"`
+	StdoutTruncated bool
+	StderrTruncated bool
+	ErrorMessage    string
+	SubmittedAt     string
+	StartTime       string
+	EndTime         string
+	ElapsedSeconds  int64
+}
+
+// runningJobs maps ids of jobs executing on this node to their cancel functions
+var runningJobs = make(map[string]context.CancelFunc)
+var runningJobsMutex sync.Mutex
+
+func NewJob(processKey string) *Job {
+	return &Job{
+		JobId:      util.NewToken().Hash,
+		ProcessKey: processKey,
+		Hostname:   process.ThisHostname,
+		Token:      util.ProcessToken.Hash,
+		Status:     JobStatusQueued,
+	}
+}
+
+// IsFinished returns true when the job has reached a terminal state
+func (job *Job) IsFinished() bool {
+	return job.Status == JobStatusSucceeded || job.Status == JobStatusFailed
+}
+
+// applyResult copies the outcome of the job's command onto the job
+func (job *Job) applyResult(result *util.CommandResult) {
+	if result == nil {
+		job.ExitCode = -1
+		return
+	}
+	job.ExitCode = result.ExitCode
+	job.Signal = result.Signal
+	job.Stdout = result.Stdout
+	job.Stderr = result.Stderr
+	job.StdoutJSON = result.StdoutJSON
+	job.StdoutTruncated = result.StdoutTruncated
+	job.StderrTruncated = result.StderrTruncated
+}
+
+// SubmitJob records a new queued job for the given process and runs its command in the
+// background. It returns as soon as the job is persisted. A job remains queued while its
+// process is at its concurrency limit; submission fails if the process queue is full.
+// The execution is recorded in the audit log once the job finishes, and callbackURL, if not
+// empty, is notified. Captured output is streamed as it is produced, see GetOutputStream.
+func SubmitJob(proc *config.Process, spec *util.CommandSpec, audit *AuditEntry, callbackURL string) (*Job, error) {
+	// A full queue, or too many live output streams, reject the job before anything is recorded
+	waitForSlot, releaseSlot, err := reserveProcessSlot(proc)
+	if err != nil {
+		auditExecution(audit, nil, err)
+		return nil, err
+	}
+	abandonSlot := func() {
+		// Waiting with a done context leaves the queue, or else takes a slot which is then released
+		canceled, cancel := context.WithCancel(context.Background())
+		cancel()
+		if waitForSlot(canceled) == nil {
+			releaseSlot()
+		}
+	}
+	job := NewJob(proc.Key)
+	job.CallbackURL = callbackURL
+	if err := streamJobOutput(job, spec); err != nil {
+		abandonSlot()
+		auditExecution(audit, nil, err)
+		return nil, err
+	}
+	if err := writeQueuedJob(job); err != nil {
+		abandonSlot()
+		forgetOutputStream(job.JobId)
+		return nil, err
+	}
+	audit.JobId = job.JobId
+	// The process timeout only applies once the job holds a slot
+	ctx, cancel := util.CommandContext(0)
+	runningJobsMutex.Lock()
+	runningJobs[job.JobId] = cancel
+	runningJobsMutex.Unlock()
+
+	go func() {
+		defer func() {
+			runningJobsMutex.Lock()
+			delete(runningJobs, job.JobId)
+			runningJobsMutex.Unlock()
+			cancel()
+		}()
+		if err := waitForSlot(ctx); err != nil {
+			failJob(job, err, audit)
+			return
+		}
+		defer releaseSlot()
+		if ctx.Err() != nil {
+			// Canceled while queued
+			failJob(job, util.ErrCommandCanceled, audit)
+			return
+		}
+		runJob(ctx, job, proc, spec, audit)
+	}()
+	return job, nil
+}
+
+// CancelJob terminates a job running on this node, along with all processes it spawned
+func CancelJob(jobId string) error {
+	runningJobsMutex.Lock()
+	cancel, found := runningJobs[jobId]
+	runningJobsMutex.Unlock()
+	if found {
+		cancel()
+		return nil
+	}
+	job, err := ReadJob(jobId)
+	if err != nil {
+		return err
+	}
+	if job.IsFinished() {
+		return fmt.Errorf("job %s is already %s", jobId, job.Status)
+	}
+	return fmt.Errorf("job %s is not running on this node; it is owned by %s", jobId, job.Hostname)
+}
+
+// failJob records a job which could not run at all
+func failJob(job *Job, err error, audit *AuditEntry) {
+	auditExecution(audit, nil, err)
+	job.applyResult(nil)
+	job.Status = JobStatusFailed
+	job.ErrorMessage = err.Error()
+	if err := writeFinishedJob(job); err != nil {
+		log.Errorf("runJob: cannot record result of job %s: %+v", job.JobId, err)
+	}
+	finishJobOutput(job)
+	notifyJobFinished(job)
+}
+
+func runJob(ctx context.Context, job *Job, proc *config.Process, spec *util.CommandSpec, audit *AuditEntry) {
+	job.Status = JobStatusRunning
+	if err := writeRunningJob(job); err != nil {
+		log.Errorf("runJob: cannot mark job %s as running: %+v", job.JobId, err)
+	}
+
+	result, err := runProcessAttempts(ctx, proc, spec, audit)
+
+	job.applyResult(result)
+	job.Status = JobStatusSucceeded
+	if err != nil {
+		job.Status = JobStatusFailed
+		job.ErrorMessage = err.Error()
+	}
+	if err := writeFinishedJob(job); err != nil {
+		log.Errorf("runJob: cannot record result of job %s: %+v", job.JobId, err)
+	}
+	finishJobOutput(job)
+	notifyJobFinished(job)
+}
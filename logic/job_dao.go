@@ -0,0 +1,165 @@
+package logic
+
+import (
+	"fmt"
+
+	"github.com/github/my-manager/config"
+	"github.com/github/my-manager/db"
+	"github.com/github/my-manager/process"
+	"github.com/github/my-manager/util"
+
+	"github.com/openark/golib/log"
+	"github.com/openark/golib/sqlutils"
+)
+
+// writeQueuedJob creates the process_job entry for a newly submitted job
+func writeQueuedJob(job *Job) error {
+	_, err := db.ExecDb(`
+			insert into process_job
+				(job_id, process_key, hostname, token, callback_url, status, submitted_at)
+			values
+				(?, ?, ?, ?, ?, ?, now())
+			`,
+		job.JobId, job.ProcessKey, job.Hostname, job.Token, job.CallbackURL, job.Status,
+	)
+	return log.Errore(err)
+}
+
+// writeRunningJob marks a job as started
+func writeRunningJob(job *Job) error {
+	_, err := db.ExecDb(`
+			update process_job set
+				status = ?,
+				start_time = now()
+			where
+				job_id = ?
+			`,
+		job.Status, job.JobId,
+	)
+	return log.Errore(err)
+}
+
+// writeFinishedJob records the outcome of a job
+func writeFinishedJob(job *Job) error {
+	_, err := db.ExecDb(`
+			update process_job set
+				status = ?,
+				exit_code = ?,
+				signal_name = ?,
+				output = ?,
+				stderr = ?,
+				stdout_truncated = ?,
+				stderr_truncated = ?,
+				error_message = ?,
+				end_time = now()
+			where
+				job_id = ?
+			`,
+		job.Status, job.ExitCode, job.Signal, job.Stdout, job.Stderr,
+		job.StdoutTruncated, job.StderrTruncated, job.ErrorMessage, job.JobId,
+	)
+	return log.Errore(err)
+}
+
+func readJobs(whereCondition string, args []interface{}, limit uint) (jobs [](*Job), err error) {
+	query := fmt.Sprintf(`
+		select
+			job_id, process_key, hostname, token, callback_url, status, exit_code, signal_name,
+			ifnull(output, '') as output,
+			ifnull(stderr, '') as stderr,
+			stdout_truncated, stderr_truncated,
+			ifnull(error_message, '') as error_message,
+			submitted_at,
+			ifnull(start_time, '') as start_time,
+			ifnull(end_time, '') as end_time,
+			ifnull(timestampdiff(second, start_time, ifnull(end_time, now())), 0) as elapsed_seconds
+		from
+			process_job
+		%s
+		order by
+			submitted_at desc, job_id
+		limit %d
+		`, whereCondition, limit)
+	err = db.QueryDB(query, args, func(m sqlutils.RowMap) error {
+		job := &Job{
+			JobId:           m.GetString("job_id"),
+			ProcessKey:      m.GetString("process_key"),
+			Hostname:        m.GetString("hostname"),
+			Token:           m.GetString("token"),
+			CallbackURL:     m.GetString("callback_url"),
+			Status:          m.GetString("status"),
+			ExitCode:        m.GetInt("exit_code"),
+			Signal:          m.GetString("signal_name"),
+			Stdout:          m.GetString("output"),
+			Stderr:          m.GetString("stderr"),
+			StdoutTruncated: m.GetBool("stdout_truncated"),
+			StderrTruncated: m.GetBool("stderr_truncated"),
+			ErrorMessage:    m.GetString("error_message"),
+			SubmittedAt:     m.GetString("submitted_at"),
+			StartTime:       m.GetString("start_time"),
+			EndTime:         m.GetString("end_time"),
+			ElapsedSeconds:  m.GetInt64("elapsed_seconds"),
+		}
+		if proc, found := GetProcess(job.ProcessKey); found && job.Status == JobStatusSucceeded {
+			result := &util.CommandResult{Stdout: job.Stdout}
+			if decodeProcessOutput(proc, result) == nil {
+				job.StdoutJSON = result.StdoutJSON
+			}
+		}
+		jobs = append(jobs, job)
+		return nil
+	})
+	return jobs, log.Errore(err)
+}
+
+// ReadJob returns a single job by its id
+func ReadJob(jobId string) (*Job, error) {
+	jobs, err := readJobs("where job_id = ?", sqlutils.Args(jobId), 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(jobs) == 0 {
+		return nil, fmt.Errorf("job not found: %s", jobId)
+	}
+	return jobs[0], nil
+}
+
+// ReadRecentJobs returns the latest jobs, optionally filtered by process key
+func ReadRecentJobs(processKey string, limit uint) ([](*Job), error) {
+	if processKey == "" {
+		return readJobs("", sqlutils.Args(), limit)
+	}
+	return readJobs("where process_key = ?", sqlutils.Args(processKey), limit)
+}
+
+// FailAbandonedJobs marks as failed any unfinished job started by a previous
+// incarnation of this node; such jobs will never report back.
+func FailAbandonedJobs() error {
+	_, err := db.ExecDb(`
+			update process_job set
+				status = ?,
+				error_message = 'abandoned: owning process is gone',
+				end_time = now()
+			where
+				hostname = ?
+				and token != ?
+				and status in (?, ?)
+			`,
+		JobStatusFailed, process.ThisHostname, util.ProcessToken.Hash, JobStatusQueued, JobStatusRunning,
+	)
+	return log.Errore(err)
+}
+
+// ExpireJobs purges finished jobs older than ProcessJobExpireHours. It is run by the active node.
+func ExpireJobs() error {
+	_, err := db.ExecDb(`
+			delete
+				from process_job
+			where
+				submitted_at < now() - interval ? hour
+				and status in (?, ?)
+			`,
+		config.Config().ProcessJobExpireHours, JobStatusSucceeded, JobStatusFailed,
+	)
+	return log.Errore(err)
+}
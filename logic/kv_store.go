@@ -0,0 +1,292 @@
+package logic
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/github/my-manager/raft"
+
+	"github.com/openark/golib/log"
+)
+
+const (
+	KVPutCommand    = "kv-put"
+	KVDeleteCommand = "kv-delete"
+	KVExpireCommand = "kv-expire"
+)
+
+const (
+	maxKVKeyBytes   = 512
+	MaxKVValueBytes = 64 * 1024
+)
+
+// KVEntry is a key of the replicated key-value store. Indexes are the raft log indexes of the
+// commands which created and last modified the key.
+type KVEntry struct {
+	Key         string
+	Value       string
+	CreateIndex uint64
+	ModifyIndex uint64
+	ExpiresAt   *time.Time `json:",omitempty"` // Set for keys with a TTL
+}
+
+// KVCasError indicates a compare-and-swap did not apply, as the key was not at the expected index
+type KVCasError struct {
+	Key           string
+	ExpectedIndex uint64
+	Entry         *KVEntry // The current entry, nil when the key does not exist
+}
+
+func (this *KVCasError) Error() string {
+	if this.Entry == nil {
+		return fmt.Sprintf("compare-and-swap on %s failed: key does not exist; expected index %d", this.Key, this.ExpectedIndex)
+	}
+	return fmt.Sprintf("compare-and-swap on %s failed: key is at index %d; expected index %d", this.Key, this.Entry.ModifyIndex, this.ExpectedIndex)
+}
+
+// IsKVCasError returns true when given error indicates a failed compare-and-swap
+func IsKVCasError(err error) bool {
+	_, ok := err.(*KVCasError)
+	return ok
+}
+
+// kvCommand is the payload of the kv-* commands. Timestamp is set by the leader publishing the
+// command, so that all nodes evaluate TTLs alike.
+type kvCommand struct {
+	Key        string `json:",omitempty"`
+	Value      string `json:",omitempty"`
+	TTLSeconds uint   `json:",omitempty"`
+	Cas        bool   // When true, the change applies only if the key is at CasIndex. 0 means the key must not exist
+	CasIndex   uint64
+	Timestamp  time.Time
+}
+
+var kvEntries = make(map[string]*KVEntry)
+var kvMutex sync.RWMutex
+
+// ValidateKVKey checks a key is non empty, short enough and free of control characters
+func ValidateKVKey(key string) error {
+	if key == "" {
+		return fmt.Errorf("empty key")
+	}
+	if len(key) > maxKVKeyBytes {
+		return fmt.Errorf("key exceeds %d bytes", maxKVKeyBytes)
+	}
+	for _, r := range key {
+		if unicode.IsControl(r) {
+			return fmt.Errorf("key must not contain control characters")
+		}
+	}
+	return nil
+}
+
+func (entry *KVEntry) isExpired(now time.Time) bool {
+	return entry.ExpiresAt != nil && !entry.ExpiresAt.After(now)
+}
+
+// ReadKVEntry returns given key as applied on this node
+func ReadKVEntry(key string) (*KVEntry, bool) {
+	kvMutex.RLock()
+	defer kvMutex.RUnlock()
+
+	entry, found := kvEntries[key]
+	if !found || entry.isExpired(time.Now()) {
+		return nil, false
+	}
+	entryCopy := *entry
+	return &entryCopy, true
+}
+
+// ReadKVEntries returns the keys beginning with given prefix as applied on this node, sorted by key
+func ReadKVEntries(prefix string) [](*KVEntry) {
+	kvMutex.RLock()
+	defer kvMutex.RUnlock()
+
+	now := time.Now()
+	entries := [](*KVEntry){}
+	for key, entry := range kvEntries {
+		if strings.HasPrefix(key, prefix) && !entry.isExpired(now) {
+			entryCopy := *entry
+			entries = append(entries, &entryCopy)
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Key < entries[j].Key
+	})
+	return entries
+}
+
+// PutKV sets a key, optionally expiring after ttlSeconds. With cas, the key is only set if it
+// is at casIndex, 0 meaning it must not exist. It must run on the leader.
+func PutKV(key string, value string, ttlSeconds uint, cas bool, casIndex uint64) (*KVEntry, error) {
+	if err := ValidateKVKey(key); err != nil {
+		return nil, err
+	}
+	if len(value) > MaxKVValueBytes {
+		return nil, fmt.Errorf("value exceeds %d bytes", MaxKVValueBytes)
+	}
+	command := &kvCommand{Key: key, Value: value, TTLSeconds: ttlSeconds, Cas: cas, CasIndex: casIndex, Timestamp: time.Now()}
+	response, err := oraft.PublishCommand(KVPutCommand, command)
+	if err != nil {
+		return nil, err
+	}
+	entry, _ := response.(*KVEntry)
+	return entry, nil
+}
+
+// DeleteKV removes a key. With cas, the key is only removed if it is at casIndex. It must
+// run on the leader.
+func DeleteKV(key string, cas bool, casIndex uint64) error {
+	if err := ValidateKVKey(key); err != nil {
+		return err
+	}
+	command := &kvCommand{Key: key, Cas: cas, CasIndex: casIndex, Timestamp: time.Now()}
+	_, err := oraft.PublishCommand(KVDeleteCommand, command)
+	return err
+}
+
+// ExpireKVEntries removes keys whose TTL elapsed, through raft, so that expiries replicate like
+// any other change. It is run by the leader.
+func ExpireKVEntries() error {
+	now := time.Now()
+	kvMutex.RLock()
+	expired := false
+	for _, entry := range kvEntries {
+		if entry.isExpired(now) {
+			expired = true
+			break
+		}
+	}
+	kvMutex.RUnlock()
+	if !expired {
+		return nil
+	}
+	_, err := oraft.PublishCommand(KVExpireCommand, &kvCommand{Timestamp: now})
+	return err
+}
+
+// expireKVEntries forgets keys whose TTL elapsed by given time, as part of the command applied
+// at given index. Must be called with the mutex held.
+func expireKVEntries(now time.Time, index uint64) {
+	for key, entry := range kvEntries {
+		if entry.isExpired(now) {
+			delete(kvEntries, key)
+			watches.keyChanged(key, index)
+		}
+	}
+}
+
+// checkKVCas verifies a compare-and-swap command against the current entry. Must be called
+// with the mutex held.
+func checkKVCas(command *kvCommand) error {
+	if !command.Cas {
+		return nil
+	}
+	entry, found := kvEntries[command.Key]
+	if !found && command.CasIndex == 0 {
+		return nil
+	}
+	if found && entry.ModifyIndex == command.CasIndex {
+		return nil
+	}
+	casError := &KVCasError{Key: command.Key, ExpectedIndex: command.CasIndex}
+	if found {
+		entryCopy := *entry
+		casError.Entry = &entryCopy
+	}
+	return casError
+}
+
+// applyKVPut is invoked by the FSM on all nodes
+func applyKVPut(value []byte, index uint64) interface{} {
+	var command kvCommand
+	if err := json.Unmarshal(value, &command); err != nil {
+		return log.Errore(err)
+	}
+	kvMutex.Lock()
+	defer kvMutex.Unlock()
+
+	expireKVEntries(command.Timestamp, index)
+	if err := checkKVCas(&command); err != nil {
+		return err
+	}
+	entry, found := kvEntries[command.Key]
+	if !found {
+		entry = &KVEntry{Key: command.Key, CreateIndex: index}
+		kvEntries[command.Key] = entry
+	}
+	entry.Value = command.Value
+	entry.ModifyIndex = index
+	watches.keyChanged(command.Key, index)
+	entry.ExpiresAt = nil
+	if command.TTLSeconds > 0 {
+		expiresAt := command.Timestamp.Add(time.Duration(command.TTLSeconds) * time.Second)
+		entry.ExpiresAt = &expiresAt
+	}
+	entryCopy := *entry
+	return &entryCopy
+}
+
+// applyKVDelete is invoked by the FSM on all nodes
+func applyKVDelete(value []byte, index uint64) interface{} {
+	var command kvCommand
+	if err := json.Unmarshal(value, &command); err != nil {
+		return log.Errore(err)
+	}
+	kvMutex.Lock()
+	defer kvMutex.Unlock()
+
+	expireKVEntries(command.Timestamp, index)
+	if err := checkKVCas(&command); err != nil {
+		return err
+	}
+	if _, found := kvEntries[command.Key]; found {
+		delete(kvEntries, command.Key)
+		watches.keyChanged(command.Key, index)
+	}
+	return nil
+}
+
+// applyKVExpire is invoked by the FSM on all nodes
+func applyKVExpire(value []byte, index uint64) interface{} {
+	var command kvCommand
+	if err := json.Unmarshal(value, &command); err != nil {
+		return log.Errore(err)
+	}
+	kvMutex.Lock()
+	defer kvMutex.Unlock()
+
+	expireKVEntries(command.Timestamp, index)
+	return nil
+}
+
+// snapshotKVEntries returns all keys, for raft snapshots
+func snapshotKVEntries() [](*KVEntry) {
+	kvMutex.RLock()
+	defer kvMutex.RUnlock()
+
+	entries := [](*KVEntry){}
+	for _, entry := range kvEntries {
+		entryCopy := *entry
+		entries = append(entries, &entryCopy)
+	}
+	return entries
+}
+
+// restoreKVEntries replaces the key-value store with the keys read from a raft snapshot
+// taken at given index
+func restoreKVEntries(entries [](*KVEntry), snapshotIndex uint64) {
+	kvMutex.Lock()
+	defer kvMutex.Unlock()
+
+	kvEntries = make(map[string]*KVEntry)
+	for _, entry := range entries {
+		kvEntries[entry.Key] = entry
+	}
+	watches.reset(snapshotIndex)
+}
@@ -0,0 +1,235 @@
+package logic
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// kvStep is a kv-* command applied at given raft index
+type kvStep struct {
+	op         string
+	command    kvCommand
+	index      uint64
+	casFailure bool
+}
+
+// kvExpected is a key expected in the store once all steps are applied
+type kvExpected struct {
+	value       string
+	createIndex uint64
+	modifyIndex uint64
+	expires     bool
+}
+
+func applyKVStep(t *testing.T, step kvStep) interface{} {
+	value, err := json.Marshal(step.command)
+	if err != nil {
+		t.Fatalf("cannot marshal command: %+v", err)
+	}
+	switch step.op {
+	case KVPutCommand:
+		return applyKVPut(value, step.index)
+	case KVDeleteCommand:
+		return applyKVDelete(value, step.index)
+	case KVExpireCommand:
+		return applyKVExpire(value, step.index)
+	}
+	t.Fatalf("unknown op %s", step.op)
+	return nil
+}
+
+func TestApplyKVCommands(t *testing.T) {
+	t0 := time.Date(2026, time.January, 14, 10, 0, 0, 0, time.UTC)
+	at := func(seconds int) time.Time {
+		return t0.Add(time.Duration(seconds) * time.Second)
+	}
+	tests := []struct {
+		name     string
+		steps    []kvStep
+		expected map[string]kvExpected
+	}{
+		{
+			name: "put then update",
+			steps: []kvStep{
+				{op: KVPutCommand, command: kvCommand{Key: "a", Value: "1", Timestamp: at(0)}, index: 10},
+				{op: KVPutCommand, command: kvCommand{Key: "a", Value: "2", Timestamp: at(1)}, index: 11},
+			},
+			expected: map[string]kvExpected{"a": {value: "2", createIndex: 10, modifyIndex: 11}},
+		},
+		{
+			name: "cas create on missing key",
+			steps: []kvStep{
+				{op: KVPutCommand, command: kvCommand{Key: "a", Value: "1", Cas: true, CasIndex: 0, Timestamp: at(0)}, index: 10},
+			},
+			expected: map[string]kvExpected{"a": {value: "1", createIndex: 10, modifyIndex: 10}},
+		},
+		{
+			name: "cas create on existing key",
+			steps: []kvStep{
+				{op: KVPutCommand, command: kvCommand{Key: "a", Value: "1", Timestamp: at(0)}, index: 10},
+				{op: KVPutCommand, command: kvCommand{Key: "a", Value: "2", Cas: true, CasIndex: 0, Timestamp: at(1)}, index: 11, casFailure: true},
+			},
+			expected: map[string]kvExpected{"a": {value: "1", createIndex: 10, modifyIndex: 10}},
+		},
+		{
+			name: "cas update at current index",
+			steps: []kvStep{
+				{op: KVPutCommand, command: kvCommand{Key: "a", Value: "1", Timestamp: at(0)}, index: 10},
+				{op: KVPutCommand, command: kvCommand{Key: "a", Value: "2", Cas: true, CasIndex: 10, Timestamp: at(1)}, index: 11},
+			},
+			expected: map[string]kvExpected{"a": {value: "2", createIndex: 10, modifyIndex: 11}},
+		},
+		{
+			name: "cas update at stale index",
+			steps: []kvStep{
+				{op: KVPutCommand, command: kvCommand{Key: "a", Value: "1", Timestamp: at(0)}, index: 10},
+				{op: KVPutCommand, command: kvCommand{Key: "a", Value: "2", Timestamp: at(1)}, index: 11},
+				{op: KVPutCommand, command: kvCommand{Key: "a", Value: "3", Cas: true, CasIndex: 10, Timestamp: at(2)}, index: 12, casFailure: true},
+			},
+			expected: map[string]kvExpected{"a": {value: "2", createIndex: 10, modifyIndex: 11}},
+		},
+		{
+			name: "cas update on missing key",
+			steps: []kvStep{
+				{op: KVPutCommand, command: kvCommand{Key: "a", Value: "1", Cas: true, CasIndex: 5, Timestamp: at(0)}, index: 10, casFailure: true},
+			},
+			expected: map[string]kvExpected{},
+		},
+		{
+			name: "delete",
+			steps: []kvStep{
+				{op: KVPutCommand, command: kvCommand{Key: "a", Value: "1", Timestamp: at(0)}, index: 10},
+				{op: KVPutCommand, command: kvCommand{Key: "b", Value: "1", Timestamp: at(0)}, index: 11},
+				{op: KVDeleteCommand, command: kvCommand{Key: "a", Timestamp: at(1)}, index: 12},
+				{op: KVDeleteCommand, command: kvCommand{Key: "missing", Timestamp: at(1)}, index: 13},
+			},
+			expected: map[string]kvExpected{"b": {value: "1", createIndex: 11, modifyIndex: 11}},
+		},
+		{
+			name: "cas delete",
+			steps: []kvStep{
+				{op: KVPutCommand, command: kvCommand{Key: "a", Value: "1", Timestamp: at(0)}, index: 10},
+				{op: KVPutCommand, command: kvCommand{Key: "b", Value: "1", Timestamp: at(0)}, index: 11},
+				{op: KVDeleteCommand, command: kvCommand{Key: "a", Cas: true, CasIndex: 9, Timestamp: at(1)}, index: 12, casFailure: true},
+				{op: KVDeleteCommand, command: kvCommand{Key: "b", Cas: true, CasIndex: 11, Timestamp: at(1)}, index: 13},
+			},
+			expected: map[string]kvExpected{"a": {value: "1", createIndex: 10, modifyIndex: 10}},
+		},
+		{
+			name: "ttl not yet elapsed",
+			steps: []kvStep{
+				{op: KVPutCommand, command: kvCommand{Key: "a", Value: "1", TTLSeconds: 60, Timestamp: at(0)}, index: 10},
+				{op: KVExpireCommand, command: kvCommand{Timestamp: at(59)}, index: 11},
+			},
+			expected: map[string]kvExpected{"a": {value: "1", createIndex: 10, modifyIndex: 10, expires: true}},
+		},
+		{
+			name: "ttl elapsed",
+			steps: []kvStep{
+				{op: KVPutCommand, command: kvCommand{Key: "a", Value: "1", TTLSeconds: 60, Timestamp: at(0)}, index: 10},
+				{op: KVPutCommand, command: kvCommand{Key: "b", Value: "1", Timestamp: at(0)}, index: 11},
+				{op: KVExpireCommand, command: kvCommand{Timestamp: at(60)}, index: 12},
+			},
+			expected: map[string]kvExpected{"b": {value: "1", createIndex: 11, modifyIndex: 11}},
+		},
+		{
+			name: "ttl elapsed before a later put",
+			steps: []kvStep{
+				{op: KVPutCommand, command: kvCommand{Key: "a", Value: "1", TTLSeconds: 60, Timestamp: at(0)}, index: 10},
+				{op: KVPutCommand, command: kvCommand{Key: "a", Value: "2", Cas: true, CasIndex: 0, Timestamp: at(61)}, index: 11},
+			},
+			expected: map[string]kvExpected{"a": {value: "2", createIndex: 11, modifyIndex: 11}},
+		},
+		{
+			name: "put without ttl clears the ttl",
+			steps: []kvStep{
+				{op: KVPutCommand, command: kvCommand{Key: "a", Value: "1", TTLSeconds: 60, Timestamp: at(0)}, index: 10},
+				{op: KVPutCommand, command: kvCommand{Key: "a", Value: "2", Timestamp: at(30)}, index: 11},
+				{op: KVExpireCommand, command: kvCommand{Timestamp: at(120)}, index: 12},
+			},
+			expected: map[string]kvExpected{"a": {value: "2", createIndex: 10, modifyIndex: 11}},
+		},
+	}
+	for _, test := range tests {
+		restoreKVEntries(nil, 0)
+		for i, step := range test.steps {
+			response := applyKVStep(t, step)
+			err, isError := response.(error)
+			if step.casFailure != IsKVCasError(err) {
+				t.Errorf("%s: step %d: expected compare-and-swap failure %t, got %+v", test.name, i, step.casFailure, response)
+			}
+			if isError && !IsKVCasError(err) {
+				t.Errorf("%s: step %d: unexpected error: %+v", test.name, i, err)
+			}
+		}
+		if len(kvEntries) != len(test.expected) {
+			t.Errorf("%s: expected %d keys, got %d", test.name, len(test.expected), len(kvEntries))
+		}
+		for key, expected := range test.expected {
+			entry, found := kvEntries[key]
+			if !found {
+				t.Errorf("%s: key %s not found", test.name, key)
+				continue
+			}
+			if entry.Value != expected.value || entry.CreateIndex != expected.createIndex || entry.ModifyIndex != expected.modifyIndex || (entry.ExpiresAt != nil) != expected.expires {
+				t.Errorf("%s: key %s: expected %+v, got %+v", test.name, key, expected, entry)
+			}
+		}
+	}
+}
+
+func TestKVCasErrorHoldsCurrentEntry(t *testing.T) {
+	restoreKVEntries(nil, 0)
+	now := time.Now()
+	applyKVStep(t, kvStep{op: KVPutCommand, command: kvCommand{Key: "a", Value: "1", Timestamp: now}, index: 10})
+	response := applyKVStep(t, kvStep{op: KVPutCommand, command: kvCommand{Key: "a", Value: "2", Cas: true, CasIndex: 9, Timestamp: now}, index: 11})
+	casError, ok := response.(*KVCasError)
+	if !ok {
+		t.Fatalf("expected a compare-and-swap failure, got %+v", response)
+	}
+	if casError.Entry == nil || casError.Entry.ModifyIndex != 10 || casError.ExpectedIndex != 9 {
+		t.Errorf("unexpected compare-and-swap failure: %+v", casError)
+	}
+}
+
+// TestKVSnapshotReplay restores a snapshot taken midway and replays the later entries: their
+// compare-and-swap outcomes, and the resulting store, must match those of the leader.
+func TestKVSnapshotReplay(t *testing.T) {
+	restoreKVEntries(nil, 0)
+	now := time.Now()
+	applyKVStep(t, kvStep{op: KVPutCommand, command: kvCommand{Key: "a", Value: "1", Timestamp: now}, index: 20})
+	data, err := NewSnapshotDataCreatorApplier().GetData()
+	if err != nil {
+		t.Fatalf("cannot take snapshot: %+v", err)
+	}
+	later := []kvStep{
+		{op: KVPutCommand, command: kvCommand{Key: "a", Value: "2", Cas: true, CasIndex: 20, Timestamp: now}, index: 21},
+		{op: KVPutCommand, command: kvCommand{Key: "b", Value: "1", Cas: true, CasIndex: 0, Timestamp: now}, index: 22},
+		{op: KVDeleteCommand, command: kvCommand{Key: "a", Cas: true, CasIndex: 21, Timestamp: now}, index: 23},
+	}
+	var leaderOutcomes []bool
+	for _, step := range later {
+		err, _ := applyKVStep(t, step).(error)
+		leaderOutcomes = append(leaderOutcomes, IsKVCasError(err))
+	}
+	leaderEntries := snapshotKVEntries()
+
+	if err := NewSnapshotDataCreatorApplier().Restore(ioutil.NopCloser(bytes.NewReader(data)), 20); err != nil {
+		t.Fatalf("cannot restore snapshot: %+v", err)
+	}
+	if watches.appliedIndex != 20 {
+		t.Errorf("expected watches reset to index 20, got %d", watches.appliedIndex)
+	}
+	for i, step := range later {
+		err, _ := applyKVStep(t, step).(error)
+		if IsKVCasError(err) != leaderOutcomes[i] {
+			t.Errorf("entry at index %d: leader compare-and-swap failure %t, replay %+v", step.index, leaderOutcomes[i], err)
+		}
+	}
+	if replayed := snapshotKVEntries(); len(replayed) != len(leaderEntries) || len(replayed) != 1 || replayed[0].Key != "b" || replayed[0].ModifyIndex != 22 {
+		t.Errorf("expected only key b at index 22 after replay, got %+v", replayed)
+	}
+}
@@ -0,0 +1,215 @@
+package logic
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/github/my-manager/util"
+)
+
+const (
+	OutputStreamStdout = "stdout"
+	OutputStreamStderr = "stderr"
+	OutputStreamExit   = "exit"
+)
+
+// maxOutputStreamRecords and maxOutputStreamBytes bound the records buffered per stream;
+// older ones are dropped
+const (
+	maxOutputStreamRecords = 10000
+	maxOutputStreamBytes   = 4 * 1024 * 1024
+)
+
+// maxOutputStreamLineBytes bounds a single streamed line; longer lines are truncated
+const maxOutputStreamLineBytes = 16 * 1024
+
+// maxOutputStreams bounds the streams held on this node. Finished streams are forgotten early
+// to make room; once all are live, jobs requiring a new one are rejected.
+const maxOutputStreams = 1000
+
+// ErrTooManyOutputStreams is returned when a job cannot be given an output stream
+var ErrTooManyOutputStreams = errors.New("too many live output streams; try again later")
+
+// outputStreamRetention is how long the output of a finished job remains available for
+// clients resuming a stream
+const outputStreamRetention = 10 * time.Minute
+
+// OutputRecord is a single line of output of a job, or, for the "exit" stream, its final
+// record. Offsets are consecutive within a job and let a client resume where it left off.
+type OutputRecord struct {
+	Offset    int64
+	Stream    string
+	Line      string `json:",omitempty"`
+	Truncated bool   `json:",omitempty"` // The line exceeded maxOutputStreamLineBytes
+	Time      time.Time
+	Result    *OutputExitResult `json:",omitempty"`
+}
+
+// OutputExitResult is the outcome of a job, held by the final record of its output
+type OutputExitResult struct {
+	Status       string
+	ExitCode     int
+	Signal       string
+	ErrorMessage string
+}
+
+// OutputStream buffers the output of a job running on this node as it is produced
+type OutputStream struct {
+	JobId string
+
+	mutex       sync.Mutex
+	records     [](*OutputRecord)
+	firstOffset int64 // Offset of records[0]
+	bytes       int   // Total length of buffered lines
+	finished    bool
+	finishedAt  time.Time
+	changed     chan bool // Closed and replaced whenever a record is added
+}
+
+var outputStreams = make(map[string]*OutputStream)
+var outputStreamsMutex sync.Mutex
+
+// newOutputStream registers the stream of a job, forgetting the earliest finished stream to make
+// room if needed. It fails when all streams held are live.
+func newOutputStream(jobId string) (*OutputStream, error) {
+	stream := &OutputStream{JobId: jobId, changed: make(chan bool)}
+	outputStreamsMutex.Lock()
+	defer outputStreamsMutex.Unlock()
+	if len(outputStreams) >= maxOutputStreams {
+		var evicted *OutputStream
+		for _, candidate := range outputStreams {
+			if finished, finishedAt := candidate.finishTime(); finished && (evicted == nil || finishedAt.Before(evicted.finishedAt)) {
+				evicted = candidate
+			}
+		}
+		if evicted == nil {
+			return nil, ErrTooManyOutputStreams
+		}
+		delete(outputStreams, evicted.JobId)
+	}
+	outputStreams[jobId] = stream
+	return stream, nil
+}
+
+// forgetOutputStream drops the stream of a job which did not get to run
+func forgetOutputStream(jobId string) {
+	outputStreamsMutex.Lock()
+	defer outputStreamsMutex.Unlock()
+	delete(outputStreams, jobId)
+}
+
+func (stream *OutputStream) finishTime() (finished bool, finishedAt time.Time) {
+	stream.mutex.Lock()
+	defer stream.mutex.Unlock()
+	return stream.finished, stream.finishedAt
+}
+
+// GetOutputStream returns the output stream of a job run by this node, if still retained
+func GetOutputStream(jobId string) (stream *OutputStream, found bool) {
+	outputStreamsMutex.Lock()
+	defer outputStreamsMutex.Unlock()
+	stream, found = outputStreams[jobId]
+	return stream, found
+}
+
+func (stream *OutputStream) append(record *OutputRecord) {
+	stream.mutex.Lock()
+	defer stream.mutex.Unlock()
+	if stream.finished {
+		return
+	}
+	record.Offset = stream.firstOffset + int64(len(stream.records))
+	record.Time = time.Now()
+	stream.records = append(stream.records, record)
+	stream.bytes += len(record.Line)
+	dropped := 0
+	for len(stream.records)-dropped > maxOutputStreamRecords || (stream.bytes > maxOutputStreamBytes && dropped < len(stream.records)-1) {
+		stream.bytes -= len(stream.records[dropped].Line)
+		dropped++
+	}
+	if dropped > 0 {
+		stream.records = append([](*OutputRecord){}, stream.records[dropped:]...)
+		stream.firstOffset += int64(dropped)
+	}
+	if record.Stream == OutputStreamExit {
+		stream.finished = true
+		stream.finishedAt = record.Time
+	}
+	close(stream.changed)
+	stream.changed = make(chan bool)
+}
+
+// writeLine is a util.CommandSpec OnOutputLine callback
+func (stream *OutputStream) writeLine(streamName string, line string) {
+	record := &OutputRecord{Stream: streamName, Line: line}
+	if len(line) > maxOutputStreamLineBytes {
+		record.Line = strings.ToValidUTF8(line[:maxOutputStreamLineBytes], "")
+		record.Truncated = true
+	}
+	stream.append(record)
+}
+
+// finish appends the final record holding the outcome of the job, and forgets the stream
+// once its retention elapses
+func (stream *OutputStream) finish(job *Job) {
+	stream.append(&OutputRecord{
+		Stream: OutputStreamExit,
+		Result: &OutputExitResult{Status: job.Status, ExitCode: job.ExitCode, Signal: job.Signal, ErrorMessage: job.ErrorMessage},
+	})
+	time.AfterFunc(outputStreamRetention, func() {
+		outputStreamsMutex.Lock()
+		defer outputStreamsMutex.Unlock()
+		delete(outputStreams, stream.JobId)
+	})
+}
+
+// Read returns the records from given offset on, waiting for some to be produced if there
+// are none yet. Records dropped from the buffer are skipped. finished is true once the stream
+// holds its final record.
+func (stream *OutputStream) Read(ctx context.Context, offset int64) (records [](*OutputRecord), finished bool, err error) {
+	for {
+		stream.mutex.Lock()
+		if offset < stream.firstOffset {
+			offset = stream.firstOffset
+		}
+		if index := offset - stream.firstOffset; index < int64(len(stream.records)) {
+			records = append(records, stream.records[index:]...)
+		}
+		finished = stream.finished
+		changed := stream.changed
+		stream.mutex.Unlock()
+
+		if len(records) > 0 || finished {
+			return records, finished, nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		}
+	}
+}
+
+// streamJobOutput sets up the command of a job so that its output is streamed. Only output
+// which is captured is streamed.
+func streamJobOutput(job *Job, spec *util.CommandSpec) error {
+	if !spec.CaptureOutput {
+		return nil
+	}
+	stream, err := newOutputStream(job.JobId)
+	if err != nil {
+		return err
+	}
+	spec.OnOutputLine = stream.writeLine
+	return nil
+}
+
+// finishJobOutput ends the output stream of a finished job, if it has one
+func finishJobOutput(job *Job) {
+	if stream, found := GetOutputStream(job.JobId); found {
+		stream.finish(job)
+	}
+}
@@ -0,0 +1,151 @@
+package logic
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/github/my-manager/config"
+	"github.com/github/my-manager/util"
+)
+
+var hostnameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_]([a-zA-Z0-9_-]{0,61}[a-zA-Z0-9_])?(\.[a-zA-Z0-9_]([a-zA-Z0-9_-]{0,61}[a-zA-Z0-9_])?)*\.?$`)
+
+// ParamError indicates a request provided an invalid or missing parameter value
+type ParamError struct {
+	Name   string
+	Reason string
+}
+
+func (this *ParamError) Error() string {
+	return fmt.Sprintf("invalid parameter %s: %s", this.Name, this.Reason)
+}
+
+// validateParamValue checks a value against the parameter's declared type and regex
+func validateParamValue(param *config.ProcessParam, value string) error {
+	if strings.ContainsRune(value, 0) {
+		return &ParamError{Name: param.Name, Reason: "must not contain NUL characters"}
+	}
+	switch param.Type {
+	case config.ParamTypeInt:
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			return &ParamError{Name: param.Name, Reason: "must be an integer"}
+		}
+	case config.ParamTypePort:
+		port, err := strconv.Atoi(value)
+		if err != nil || port < 1 || port > 65535 {
+			return &ParamError{Name: param.Name, Reason: "must be a port number between 1 and 65535"}
+		}
+	case config.ParamTypeHostname:
+		if net.ParseIP(value) == nil && (len(value) > 253 || !hostnameRegexp.MatchString(value)) {
+			return &ParamError{Name: param.Name, Reason: "must be a hostname or IP address"}
+		}
+	case config.ParamTypePath:
+		if value == "" || strings.HasPrefix(value, "-") {
+			return &ParamError{Name: param.Name, Reason: "must be a non empty path not beginning with '-'"}
+		}
+		for _, c := range value {
+			if c < ' ' || c == 0x7f {
+				return &ParamError{Name: param.Name, Reason: "must not contain control characters"}
+			}
+		}
+	case config.ParamTypeEnum:
+		found := false
+		for _, allowed := range param.Values {
+			if value == allowed {
+				found = true
+			}
+		}
+		if !found {
+			return &ParamError{Name: param.Name, Reason: fmt.Sprintf("must be one of: %s", strings.Join(param.Values, ", "))}
+		}
+	}
+	if regex := param.CompiledRegex(); regex != nil && !regex.MatchString(value) {
+		return &ParamError{Name: param.Name, Reason: fmt.Sprintf("must match %s", param.Regex)}
+	}
+	return nil
+}
+
+// NewProcessCommand validates given values against the process' parameter declarations and
+// returns the command to run. Values are never spliced into the script text: for bash and sh,
+// every {name} placeholder is rewritten into a quoted reference to the MANAGER_PARAM_<NAME>
+// environment variable, and values are additionally passed as positional arguments in
+// declaration order. With the exec interpreter, the script is split into words, and words
+// which are a {name} placeholder are replaced by the value, as a single argument. Other
+// interpreters only get values through the environment and arguments; their scripts may not
+// hold placeholders. Values of secret parameters reach the command as given, and are masked
+// wherever the command, its output or errors are logged or returned.
+func NewProcessCommand(proc *config.Process, values map[string]string) (*util.CommandSpec, error) {
+	spec := &util.CommandSpec{
+		Text:          proc.Script,
+		Interpreter:   proc.Interpreter,
+		Dir:           proc.WorkingDirectory,
+		Env:           append([]string{}, proc.Env...),
+		EnvAllowList:  proc.EnvAllowList,
+		CaptureOutput: proc.IsOutputCaptured(),
+		Limits: &util.ResourceLimits{
+			MemoryBytes:  uint64(util.ConvStrToUInt(proc.MemoryLimitBytes)),
+			CPUSeconds:   uint64(util.ConvStrToUInt(proc.CpuTimeLimitSeconds)),
+			OpenFiles:    uint64(util.ConvStrToUInt(proc.OpenFilesLimit)),
+			Processes:    uint64(util.ConvStrToUInt(proc.ProcessesLimit)),
+			CgroupParent: config.Config().CgroupParent,
+		},
+		MaxOutputBytes: int(util.ConvStrToUInt(proc.MaxOutputBytes)),
+	}
+	if proc.RunAsUid != "" {
+		uid, _ := strconv.ParseUint(proc.RunAsUid, 10, 32)
+		gid, _ := strconv.ParseUint(proc.RunAsGid, 10, 32)
+		spec.Credential = &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}
+	}
+	isShell := proc.Interpreter == "" || proc.Interpreter == config.InterpreterBash || proc.Interpreter == config.InterpreterSh
+	for i := range proc.Params {
+		param := &proc.Params[i]
+		value, provided := values[param.Name]
+		if provided {
+			if err := validateParamValue(param, value); err != nil {
+				return nil, err
+			}
+		} else if param.Required {
+			return nil, &ParamError{Name: param.Name, Reason: "is required"}
+		}
+		envName := param.EnvName()
+		reference := fmt.Sprintf(`"${%s}"`, envName)
+		placeholder := fmt.Sprintf("{%s}", param.Name)
+		if isShell {
+			for _, quote := range []string{"'", `"`, ""} {
+				spec.Text = strings.Replace(spec.Text, quote+placeholder+quote, reference, -1)
+			}
+		}
+		spec.Env = append(spec.Env, fmt.Sprintf("%s=%s", envName, value))
+		spec.Arguments = append(spec.Arguments, value)
+		if param.IsSecret() && value != "" {
+			spec.Secrets = append(spec.Secrets, value)
+		}
+	}
+	if proc.Interpreter == config.InterpreterExec {
+		spec.Argv = execArgv(proc, values)
+		if len(spec.Argv) == 0 {
+			return nil, fmt.Errorf("process %s has no command to exec", proc.Key)
+		}
+	}
+	return spec, nil
+}
+
+// execArgv splits the script of an exec process into words, substituting declared parameter
+// placeholders, optionally quoted, which make up an entire word
+func execArgv(proc *config.Process, values map[string]string) (argv []string) {
+	for _, word := range strings.Fields(proc.Script) {
+		for i := range proc.Params {
+			placeholder := fmt.Sprintf("{%s}", proc.Params[i].Name)
+			if word == placeholder || word == "'"+placeholder+"'" || word == `"`+placeholder+`"` {
+				word = values[proc.Params[i].Name]
+				break
+			}
+		}
+		argv = append(argv, word)
+	}
+	return argv
+}
@@ -0,0 +1,246 @@
+package logic
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/github/my-manager/config"
+	"github.com/github/my-manager/raft"
+
+	"github.com/openark/golib/log"
+)
+
+const (
+	RegisterProcessCommand   = "register-process"
+	UnregisterProcessCommand = "unregister-process"
+)
+
+// RegisteredProcess is a process definition managed through the API and replicated via raft
+type RegisteredProcess struct {
+	Process *config.Process
+	Version uint64 // Raft log index of the command which last changed this definition
+}
+
+// ProcessRegistry is the replicated catalog of API managed processes. Its Version is the raft
+// log index of the last applied change, so that nodes can tell whether they converged, and
+// replaying a command yields the same versions on every node.
+type ProcessRegistry struct {
+	Version   uint64
+	Processes []*RegisteredProcess
+}
+
+// processRegistryCommand is the payload of register-process and unregister-process commands
+type processRegistryCommand struct {
+	Key             string
+	Process         *config.Process `json:",omitempty"`
+	Create          bool            // When true, registering fails if the key exists
+	ExpectedVersion uint64          // When non zero, the change fails unless the current definition has this version
+}
+
+var processRegistry = &ProcessRegistry{Processes: []*RegisteredProcess{}}
+var processRegistryMutex sync.RWMutex
+
+// shadowedProcessKeys are the registered processes last found shadowed by the config file
+var shadowedProcessKeys = make(map[string]bool)
+
+// AllProcesses returns the processes defined in the config file followed by those in the
+// replicated registry. Static definitions take precedence over registered ones with the same key.
+func AllProcesses() []*config.Process {
+	processRegistryMutex.RLock()
+	defer processRegistryMutex.RUnlock()
+
+	processes := append([]*config.Process{}, config.Config().Processes...)
+	staticKeys := make(map[string]bool)
+	for _, proc := range processes {
+		staticKeys[proc.Key] = true
+	}
+	for _, registered := range processRegistry.Processes {
+		if staticKeys[registered.Process.Key] {
+			continue
+		}
+		processes = append(processes, registered.Process)
+	}
+	return processes
+}
+
+// rescheduleProcesses schedules the processes currently defined, once the config file or the
+// registry changed. Registered processes which the config file starts shadowing are warned of.
+func rescheduleProcesses() {
+	warnShadowedProcesses()
+	ScheduleProcesses(AllProcesses())
+}
+
+// warnShadowedProcesses logs registered processes newly shadowed by a process of the same key
+// in the config file, once, rather than on each lookup
+func warnShadowedProcesses() {
+	processRegistryMutex.Lock()
+	defer processRegistryMutex.Unlock()
+
+	staticKeys := make(map[string]bool)
+	for _, proc := range config.Config().Processes {
+		staticKeys[proc.Key] = true
+	}
+	shadowed := make(map[string]bool)
+	for _, registered := range processRegistry.Processes {
+		if key := registered.Process.Key; staticKeys[key] {
+			if !shadowedProcessKeys[key] {
+				log.Warningf("registered process %s is shadowed by a process of the same key in the config file", key)
+			}
+			shadowed[key] = true
+		}
+	}
+	shadowedProcessKeys = shadowed
+}
+
+// ReadProcessRegistry returns a copy of the registry as applied on this node
+func ReadProcessRegistry() *ProcessRegistry {
+	processRegistryMutex.RLock()
+	defer processRegistryMutex.RUnlock()
+
+	return &ProcessRegistry{
+		Version:   processRegistry.Version,
+		Processes: append([]*RegisteredProcess{}, processRegistry.Processes...),
+	}
+}
+
+// RegisterProcess creates or updates a process definition across the raft group. The definition
+// is validated here, before publishing: applying it is unconditional on all nodes.
+func RegisterProcess(proc *config.Process, create bool, expectedVersion uint64) (*RegisteredProcess, error) {
+	if err := proc.Validate(); err != nil {
+		return nil, err
+	}
+	if err := proc.ValidateCgroupLimits(config.Config().CgroupParent); err != nil {
+		return nil, err
+	}
+	for _, static := range config.Config().Processes {
+		if static.Key == proc.Key {
+			return nil, fmt.Errorf("process %s is defined in the config file and cannot be managed through the API", proc.Key)
+		}
+	}
+	command := &processRegistryCommand{Key: proc.Key, Process: proc, Create: create, ExpectedVersion: expectedVersion}
+	response, err := oraft.PublishCommand(RegisterProcessCommand, command)
+	if err != nil {
+		return nil, err
+	}
+	registered, _ := response.(*RegisteredProcess)
+	return registered, nil
+}
+
+// UnregisterProcess removes a process definition across the raft group
+func UnregisterProcess(key string, expectedVersion uint64) error {
+	command := &processRegistryCommand{Key: key, ExpectedVersion: expectedVersion}
+	_, err := oraft.PublishCommand(UnregisterProcessCommand, command)
+	return err
+}
+
+// prepareRegisteredProcess compiles the regexes and fills in the defaults of a replicated
+// definition. The leader validated it before publishing; should this node disagree, e.g.
+// lacking a time zone, the definition still applies, so that the registry is the same on all
+// nodes, and the problem is logged.
+func prepareRegisteredProcess(proc *config.Process) {
+	if err := proc.Validate(); err != nil {
+		log.Errorf("registered process %s does not validate on this node; applying it as is: %+v", proc.Key, err)
+	}
+}
+
+// findRegisteredProcess returns the index of given key in the registry, or -1. Must be called
+// with the mutex held.
+func (registry *ProcessRegistry) findRegisteredProcess(key string) int {
+	for i, registered := range registry.Processes {
+		if registered.Process.Key == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// applyRegisterProcess is invoked by the FSM on all nodes, with the index of the command
+func applyRegisterProcess(value []byte, index uint64) interface{} {
+	var command processRegistryCommand
+	if err := json.Unmarshal(value, &command); err != nil {
+		return log.Errore(err)
+	}
+	if command.Process == nil {
+		return log.Errorf("register-process: no process definition for %s", command.Key)
+	}
+	prepareRegisteredProcess(command.Process)
+	processRegistryMutex.Lock()
+	i := processRegistry.findRegisteredProcess(command.Key)
+	if i >= 0 && command.Create {
+		processRegistryMutex.Unlock()
+		return fmt.Errorf("process %s already exists", command.Key)
+	}
+	if i < 0 && !command.Create {
+		processRegistryMutex.Unlock()
+		return fmt.Errorf("process not found: %s", command.Key)
+	}
+	if i >= 0 && command.ExpectedVersion != 0 && processRegistry.Processes[i].Version != command.ExpectedVersion {
+		processRegistryMutex.Unlock()
+		return fmt.Errorf("process %s was changed at version %d; expected version %d", command.Key, processRegistry.Processes[i].Version, command.ExpectedVersion)
+	}
+	processRegistry.Version = index
+	registered := &RegisteredProcess{Process: command.Process, Version: index}
+	if i >= 0 {
+		processRegistry.Processes[i] = registered
+	} else {
+		processRegistry.Processes = append(processRegistry.Processes, registered)
+		sort.SliceStable(processRegistry.Processes, func(i, j int) bool {
+			return processRegistry.Processes[i].Process.Key < processRegistry.Processes[j].Process.Key
+		})
+	}
+	processRegistryMutex.Unlock()
+
+	log.Infof("registered process %s at version %d", command.Key, registered.Version)
+	rescheduleProcesses()
+	return registered
+}
+
+// applyUnregisterProcess is invoked by the FSM on all nodes, with the index of the command
+func applyUnregisterProcess(value []byte, index uint64) interface{} {
+	var command processRegistryCommand
+	if err := json.Unmarshal(value, &command); err != nil {
+		return log.Errore(err)
+	}
+	processRegistryMutex.Lock()
+	i := processRegistry.findRegisteredProcess(command.Key)
+	if i < 0 {
+		processRegistryMutex.Unlock()
+		return fmt.Errorf("process not found: %s", command.Key)
+	}
+	if command.ExpectedVersion != 0 && processRegistry.Processes[i].Version != command.ExpectedVersion {
+		processRegistryMutex.Unlock()
+		return fmt.Errorf("process %s was changed at version %d; expected version %d", command.Key, processRegistry.Processes[i].Version, command.ExpectedVersion)
+	}
+	processRegistry.Version = index
+	processRegistry.Processes = append(processRegistry.Processes[:i], processRegistry.Processes[i+1:]...)
+	processRegistryMutex.Unlock()
+
+	log.Infof("unregistered process %s at version %d", command.Key, index)
+	rescheduleProcesses()
+	return nil
+}
+
+// restoreProcessRegistry replaces the registry with one read from a raft snapshot
+func restoreProcessRegistry(registry *ProcessRegistry) error {
+	if registry == nil {
+		registry = &ProcessRegistry{}
+	}
+	processes := []*RegisteredProcess{}
+	for _, registered := range registry.Processes {
+		if registered == nil || registered.Process == nil || registered.Process.Key == "" {
+			log.Errorf("restore process registry: skipping malformed entry %+v", registered)
+			continue
+		}
+		prepareRegisteredProcess(registered.Process)
+		processes = append(processes, registered)
+	}
+	processRegistryMutex.Lock()
+	processRegistry = &ProcessRegistry{Version: registry.Version, Processes: processes}
+	processRegistryMutex.Unlock()
+
+	log.Infof("restored process registry at version %d with %d processes", registry.Version, len(processes))
+	rescheduleProcesses()
+	return nil
+}
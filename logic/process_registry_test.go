@@ -0,0 +1,137 @@
+package logic
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/github/my-manager/config"
+)
+
+// registryLogEntry is a register-process or unregister-process command at a raft index
+type registryLogEntry struct {
+	index   uint64
+	op      string
+	command processRegistryCommand
+}
+
+func applyRegistryLog(t *testing.T, applier *CommandApplier, entries ...registryLogEntry) []interface{} {
+	responses := []interface{}{}
+	for _, entry := range entries {
+		value, err := json.Marshal(entry.command)
+		if err != nil {
+			t.Fatalf("cannot marshal command at index %d: %+v", entry.index, err)
+		}
+		responses = append(responses, applier.ApplyCommand(entry.op, value, entry.index))
+	}
+	return responses
+}
+
+func registerEntry(index uint64, key string, create bool, expectedVersion uint64) registryLogEntry {
+	proc := &config.Process{Key: key, Script: "echo " + key}
+	return registryLogEntry{index: index, op: RegisterProcessCommand, command: processRegistryCommand{Key: key, Process: proc, Create: create, ExpectedVersion: expectedVersion}}
+}
+
+func unregisterEntry(index uint64, key string, expectedVersion uint64) registryLogEntry {
+	return registryLogEntry{index: index, op: UnregisterProcessCommand, command: processRegistryCommand{Key: key, ExpectedVersion: expectedVersion}}
+}
+
+func registryVersions(registry *ProcessRegistry) map[string]uint64 {
+	versions := make(map[string]uint64)
+	for _, registered := range registry.Processes {
+		versions[registered.Process.Key] = registered.Version
+	}
+	return versions
+}
+
+func TestProcessRegistryVersionsAreRaftIndexes(t *testing.T) {
+	restoreProcessRegistry(nil)
+	applier := NewCommandApplier()
+
+	responses := applyRegistryLog(t, applier,
+		registerEntry(5, "a", true, 0),
+		registerEntry(6, "b", true, 0),
+		registerEntry(7, "a", true, 0),  // Already exists
+		registerEntry(8, "a", false, 5), // Update at the current version
+		registerEntry(9, "a", false, 5), // Stale version
+		unregisterEntry(10, "b", 6),
+		unregisterEntry(11, "b", 0), // Already gone
+	)
+	for i, failing := range []bool{false, false, true, false, true, false, true} {
+		if _, isError := responses[i].(error); isError != failing {
+			t.Errorf("command %d: expected failure %t, got %+v", i, failing, responses[i])
+		}
+	}
+	registry := ReadProcessRegistry()
+	if registry.Version != 10 {
+		t.Errorf("expected registry version 10, got %d", registry.Version)
+	}
+	if versions := registryVersions(registry); !reflect.DeepEqual(versions, map[string]uint64{"a": 8}) {
+		t.Errorf("unexpected process versions: %+v", versions)
+	}
+}
+
+// TestProcessRegistrySnapshotReplay restores a snapshot taken midway, as a restarted node or a
+// follower installing the snapshot would, then replays the later entries: the registry must
+// end up identical to that of the node which applied the log throughout.
+func TestProcessRegistrySnapshotReplay(t *testing.T) {
+	restoreProcessRegistry(nil)
+	applier := NewCommandApplier()
+	snapshotApplier := NewSnapshotDataCreatorApplier()
+
+	applyRegistryLog(t, applier,
+		registerEntry(3, "a", true, 0),
+		registerEntry(4, "b", true, 0),
+	)
+	data, err := snapshotApplier.GetData()
+	if err != nil {
+		t.Fatalf("cannot take snapshot: %+v", err)
+	}
+	laterEntries := []registryLogEntry{
+		registerEntry(5, "c", true, 0),
+		registerEntry(6, "a", false, 3),
+		unregisterEntry(7, "b", 4),
+	}
+	leaderResponses := applyRegistryLog(t, applier, laterEntries...)
+	leaderRegistry := ReadProcessRegistry()
+
+	if err := snapshotApplier.Restore(ioutil.NopCloser(bytes.NewReader(data)), 4); err != nil {
+		t.Fatalf("cannot restore snapshot: %+v", err)
+	}
+	if registry := ReadProcessRegistry(); registry.Version != 4 || len(registry.Processes) != 2 {
+		t.Fatalf("expected the registry at version 4 with 2 processes after restore, got version %d with %d", registry.Version, len(registry.Processes))
+	}
+	replayResponses := applyRegistryLog(t, applier, laterEntries...)
+	for i := range laterEntries {
+		_, leaderFailed := leaderResponses[i].(error)
+		_, replayFailed := replayResponses[i].(error)
+		if leaderFailed || replayFailed {
+			t.Errorf("entry at index %d: leader got %+v, replay got %+v", laterEntries[i].index, leaderResponses[i], replayResponses[i])
+		}
+	}
+	replayedRegistry := ReadProcessRegistry()
+	if replayedRegistry.Version != leaderRegistry.Version {
+		t.Errorf("expected registry version %d after replay, got %d", leaderRegistry.Version, replayedRegistry.Version)
+	}
+	if leaderVersions, replayedVersions := registryVersions(leaderRegistry), registryVersions(replayedRegistry); !reflect.DeepEqual(leaderVersions, replayedVersions) {
+		t.Errorf("expected process versions %+v after replay, got %+v", leaderVersions, replayedVersions)
+	}
+}
+
+func TestRestoreProcessRegistrySkipsMalformedEntries(t *testing.T) {
+	registry := &ProcessRegistry{Version: 12, Processes: []*RegisteredProcess{
+		nil,
+		{Version: 3},
+		{Process: &config.Process{}, Version: 4},
+		{Process: &config.Process{Key: "ok", Script: "true"}, Version: 12},
+	}}
+	if err := restoreProcessRegistry(registry); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	restored := ReadProcessRegistry()
+	if restored.Version != 12 || !reflect.DeepEqual(registryVersions(restored), map[string]uint64{"ok": 12}) {
+		t.Errorf("unexpected restored registry: version %d, processes %+v", restored.Version, registryVersions(restored))
+	}
+}
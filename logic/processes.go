@@ -0,0 +1,86 @@
+package logic
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/github/my-manager/config"
+	"github.com/github/my-manager/util"
+)
+
+// GetProcess returns the configured or registered process by given key
+func GetProcess(key string) (proc *config.Process, found bool) {
+	for _, process := range AllProcesses() {
+		if process.Key == key {
+			return process, true
+		}
+	}
+	return nil, false
+}
+
+// ProcessTimeout returns the execution timeout of a process: its "timeoutSeconds", or
+// else the global ProcessTimeoutSeconds. Zero means no timeout.
+func ProcessTimeout(proc *config.Process) time.Duration {
+	timeoutSeconds := util.ConvStrToUInt(proc.TimeoutSeconds)
+	if timeoutSeconds == 0 {
+		timeoutSeconds = config.Config().ProcessTimeoutSeconds
+	}
+	return time.Duration(timeoutSeconds) * time.Second
+}
+
+// RunProcessCommand synchronously runs given command on behalf of a process, bounded by
+// the process timeout, once the process is below its concurrency limit. Failed runs are
+// retried as the process retry policy allows. Each attempt is recorded in the audit log.
+func RunProcessCommand(proc *config.Process, spec *util.CommandSpec, audit *AuditEntry) (*util.CommandResult, error) {
+	ctx := context.Background()
+	release, err := acquireProcessSlot(ctx, proc)
+	if err != nil {
+		auditExecution(audit, nil, err)
+		return nil, err
+	}
+	defer release()
+
+	return runProcessAttempts(ctx, proc, spec, audit)
+}
+
+// beginProcessRun takes the cluster lock of the process if it requires one, and returns the
+// context bounding the run: it ends with given context, upon the process timeout, or should
+// the cluster lock be lost. The returned function must be called once the run completes.
+func beginProcessRun(ctx context.Context, proc *config.Process) (runCtx context.Context, end func(), err error) {
+	var cancel context.CancelFunc
+	if timeout := ProcessTimeout(proc); timeout > 0 {
+		runCtx, cancel = context.WithTimeout(ctx, timeout)
+	} else {
+		runCtx, cancel = context.WithCancel(ctx)
+	}
+	unlock, err := holdClusterLock(proc, cancel)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	return runCtx, func() {
+		unlock()
+		cancel()
+	}, nil
+}
+
+func runProcessCommand(ctx context.Context, proc *config.Process, spec *util.CommandSpec) (*util.CommandResult, error) {
+	result, err := util.RunCommand(ctx, spec)
+	if err != nil {
+		return result, err
+	}
+	return result, decodeProcessOutput(proc, result)
+}
+
+// decodeProcessOutput populates StdoutJSON for processes declaring outputFormat "json"
+func decodeProcessOutput(proc *config.Process, result *util.CommandResult) error {
+	if proc.OutputFormat != config.OutputFormatJSON || !proc.IsOutputCaptured() {
+		return nil
+	}
+	if err := json.Unmarshal([]byte(result.Stdout), &result.StdoutJSON); err != nil {
+		return fmt.Errorf("cannot decode stdout of %s as JSON: %+v", proc.Key, err)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package logic
+
+import (
+	"context"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/github/my-manager/config"
+	"github.com/github/my-manager/util"
+
+	"github.com/openark/golib/log"
+)
+
+const (
+	defaultRetryBackoff           = time.Second
+	defaultRetryBackoffMultiplier = 2.0
+	defaultRetryMaxBackoff        = 300 * time.Second
+)
+
+var retryJitterRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+var retryJitterRandMutex sync.Mutex
+
+// retryPolicy tells whether and when a failed run of a process is attempted again
+type retryPolicy struct {
+	maxAttempts int
+	backoff     time.Duration
+	multiplier  float64
+	maxBackoff  time.Duration
+	jitter      float64
+	exitCodes   []int
+}
+
+func newRetryPolicy(proc *config.Process) *retryPolicy {
+	policy := &retryPolicy{
+		maxAttempts: int(util.ConvStrToUInt(proc.RetryMaxAttempts)),
+		backoff:     time.Duration(util.ConvStrToUInt(proc.RetryBackoffSeconds)) * time.Second,
+		multiplier:  defaultRetryBackoffMultiplier,
+		maxBackoff:  time.Duration(util.ConvStrToUInt(proc.RetryMaxBackoffSeconds)) * time.Second,
+		exitCodes:   proc.RetryExitCodes,
+	}
+	if policy.maxAttempts < 1 {
+		policy.maxAttempts = 1
+	}
+	if policy.backoff == 0 {
+		policy.backoff = defaultRetryBackoff
+	}
+	if policy.maxBackoff == 0 {
+		policy.maxBackoff = defaultRetryMaxBackoff
+	}
+	// Both are validated when the process is read
+	if multiplier, err := strconv.ParseFloat(proc.RetryBackoffMultiplier, 64); err == nil {
+		policy.multiplier = multiplier
+	}
+	if jitter, err := strconv.ParseFloat(proc.RetryJitter, 64); err == nil {
+		policy.jitter = jitter
+	}
+	return policy
+}
+
+// isRetryable tells whether a failed attempt may be retried: the command must have run and
+// failed, with one of the retryable exit codes if any are listed
+func (policy *retryPolicy) isRetryable(result *util.CommandResult) bool {
+	if result == nil || result.ExitCode == 0 {
+		return false
+	}
+	if len(policy.exitCodes) == 0 {
+		return true
+	}
+	for _, exitCode := range policy.exitCodes {
+		if exitCode == result.ExitCode {
+			return true
+		}
+	}
+	return false
+}
+
+// delay returns the wait before the attempt following given one
+func (policy *retryPolicy) delay(attempt int) time.Duration {
+	delay := float64(policy.backoff)
+	for i := 1; i < attempt && delay < float64(policy.maxBackoff); i++ {
+		delay *= policy.multiplier
+	}
+	if delay > float64(policy.maxBackoff) {
+		delay = float64(policy.maxBackoff)
+	}
+	if policy.jitter > 0 {
+		retryJitterRandMutex.Lock()
+		delay *= 1 + policy.jitter*(2*retryJitterRand.Float64()-1)
+		retryJitterRandMutex.Unlock()
+	}
+	return time.Duration(delay)
+}
+
+// runProcessAttempts runs given command on behalf of a process, retrying failed runs as its
+// retry policy allows. Each attempt is bounded by the process timeout, holds the cluster lock
+// if the process requires one, and is recorded in the audit log. Retries stop once given
+// context is done.
+func runProcessAttempts(ctx context.Context, proc *config.Process, spec *util.CommandSpec, audit *AuditEntry) (*util.CommandResult, error) {
+	policy := newRetryPolicy(proc)
+	for attempt := 1; ; attempt++ {
+		attemptAudit := *audit
+		attemptAudit.Attempt = attempt
+		var result *util.CommandResult
+		runCtx, end, err := beginProcessRun(ctx, proc)
+		if err == nil {
+			result, err = runProcessCommand(runCtx, proc, spec)
+			end()
+		}
+		auditExecution(&attemptAudit, result, err)
+		if err == nil || attempt >= policy.maxAttempts || ctx.Err() != nil || !policy.isRetryable(result) {
+			return result, err
+		}
+		delay := policy.delay(attempt)
+		log.Warningf("process %s: attempt %d of %d failed with exit code %d; retrying in %+v", proc.Key, attempt, policy.maxAttempts, result.ExitCode, delay)
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			log.Warningf("process %s: run canceled; not retrying", proc.Key)
+			return result, err
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package logic
+
+import (
+	"testing"
+	"time"
+
+	"github.com/github/my-manager/config"
+	"github.com/github/my-manager/util"
+)
+
+func TestRetryPolicyDefaults(t *testing.T) {
+	policy := newRetryPolicy(&config.Process{Key: "p"})
+	if policy.maxAttempts != 1 || policy.backoff != defaultRetryBackoff || policy.multiplier != defaultRetryBackoffMultiplier || policy.maxBackoff != defaultRetryMaxBackoff {
+		t.Errorf("unexpected default policy: %+v", policy)
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := newRetryPolicy(&config.Process{Key: "p", RetryMaxAttempts: "10", RetryBackoffSeconds: "2", RetryBackoffMultiplier: "3", RetryMaxBackoffSeconds: "60"})
+	// 2s, tripled after each attempt, capped at 60s
+	expected := []time.Duration{2 * time.Second, 6 * time.Second, 18 * time.Second, 54 * time.Second, 60 * time.Second, 60 * time.Second}
+	for i, delay := range expected {
+		if actual := policy.delay(i + 1); actual != delay {
+			t.Errorf("after attempt %d: expected a delay of %+v, got %+v", i+1, delay, actual)
+		}
+	}
+
+	policy.jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if delay := policy.delay(2); delay < 3*time.Second || delay > 9*time.Second {
+			t.Fatalf("expected a delay within 50%% of 6s, got %+v", delay)
+		}
+	}
+}
+
+func TestRetryPolicyIsRetryable(t *testing.T) {
+	anyFailure := newRetryPolicy(&config.Process{Key: "p", RetryMaxAttempts: "3"})
+	if anyFailure.isRetryable(nil) {
+		t.Errorf("expected a command which did not run not to be retried")
+	}
+	if anyFailure.isRetryable(&util.CommandResult{ExitCode: 0}) {
+		t.Errorf("expected success not to be retried")
+	}
+	if !anyFailure.isRetryable(&util.CommandResult{ExitCode: 1}) || !anyFailure.isRetryable(&util.CommandResult{ExitCode: -1}) {
+		t.Errorf("expected any failure to be retried when no exit code is listed")
+	}
+
+	listed := newRetryPolicy(&config.Process{Key: "p", RetryMaxAttempts: "3", RetryExitCodes: []int{75, -1}})
+	for exitCode, retryable := range map[int]bool{75: true, -1: true, 1: false, 0: false} {
+		if listed.isRetryable(&util.CommandResult{ExitCode: exitCode}) != retryable {
+			t.Errorf("exit code %d: expected retryable %t", exitCode, retryable)
+		}
+	}
+}
@@ -0,0 +1,340 @@
+package logic
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/github/my-manager/config"
+	"github.com/github/my-manager/raft"
+	"github.com/github/my-manager/util"
+
+	"github.com/openark/golib/log"
+)
+
+const defaultRunIntervalSeconds = 60
+
+// OutScripts is a process scheduled to run periodically, either every RunIntervalSeconds
+// or whenever its cron expression fires
+type OutScripts struct {
+	Key                string
+	RunIntervalSeconds string
+	Cron               string
+	Timezone           string
+	OverlapPolicy      string
+	OutputFlag         string
+	Script             string
+	Interval           time.Duration
+	CronSchedule       *util.CronSchedule
+
+	process       *config.Process
+	stop          chan struct{}
+	anchor        time.Time
+	mutex         sync.Mutex
+	nextRunTime   time.Time
+	lastRunTime   time.Time
+	running       bool
+	pending       bool
+	cancelRun     context.CancelFunc
+	runCount      int64
+	skippedCount  int64
+	queuedCount   int64
+	replacedCount int64
+}
+
+// ScheduleStatus describes a scheduled process and when it fires next
+type ScheduleStatus struct {
+	Key                string
+	RunIntervalSeconds string
+	Cron               string
+	Timezone           string
+	OverlapPolicy      string
+	NextRunTime        time.Time
+	LastRunTime        time.Time
+	IsRunning          bool
+	IsPending          bool  // A queued run awaits completion of the current one
+	RunCount           int64 // Runs started on this node
+	SkippedCount       int64 // Activations dropped because a run was in progress
+	QueuedCount        int64 // Activations deferred until the run in progress completed
+	ReplacedCount      int64 // Runs canceled in favor of a new activation
+	IsActiveNode       bool  // Whether this node is the one running scheduled processes
+}
+
+var scheduledScripts = []*OutScripts{}
+var scheduledScriptsMutex sync.Mutex
+
+// NewOutScripts sets up the schedule of given process. Its command is built anew for each run,
+// so that reloaded global settings, such as CgroupParent or ProcessTimeoutSeconds, apply.
+func NewOutScripts(proc *config.Process) (*OutScripts, error) {
+	if _, err := NewProcessCommand(proc, nil); err != nil {
+		return nil, err
+	}
+	outScripts := &OutScripts{
+		Key:                proc.Key,
+		RunIntervalSeconds: proc.RunIntervalSeconds,
+		Cron:               proc.Cron,
+		Timezone:           proc.Timezone,
+		OverlapPolicy:      proc.OverlapPolicy,
+		Script:             proc.Script,
+		OutputFlag:         proc.OutputFlag,
+		CronSchedule:       proc.CronSchedule(),
+		process:            proc,
+		stop:               make(chan struct{}),
+		anchor:             time.Now(),
+	}
+	if outScripts.CronSchedule == nil {
+		runIntervalSeconds := util.ConvStrToUInt(proc.RunIntervalSeconds)
+		if runIntervalSeconds == 0 {
+			runIntervalSeconds = defaultRunIntervalSeconds
+		}
+		outScripts.Interval = time.Duration(runIntervalSeconds) * time.Second
+	}
+	return outScripts, nil
+}
+
+// computeNextRunTime returns the first activation strictly after given time. Interval
+// schedules are aligned to the time they were set up, so slow runs do not shift later ones.
+func (outscript *OutScripts) computeNextRunTime(after time.Time) time.Time {
+	if outscript.CronSchedule != nil {
+		return outscript.CronSchedule.Next(after)
+	}
+	elapsedIntervals := after.Sub(outscript.anchor) / outscript.Interval
+	return outscript.anchor.Add((elapsedIntervals + 1) * outscript.Interval)
+}
+
+func (outscript *OutScripts) status() ScheduleStatus {
+	outscript.mutex.Lock()
+	defer outscript.mutex.Unlock()
+	return ScheduleStatus{
+		Key:                outscript.Key,
+		RunIntervalSeconds: outscript.RunIntervalSeconds,
+		Cron:               outscript.Cron,
+		Timezone:           outscript.Timezone,
+		OverlapPolicy:      outscript.OverlapPolicy,
+		NextRunTime:        outscript.nextRunTime,
+		LastRunTime:        outscript.lastRunTime,
+		IsRunning:          outscript.running,
+		IsPending:          outscript.pending,
+		RunCount:           outscript.runCount,
+		SkippedCount:       outscript.skippedCount,
+		QueuedCount:        outscript.queuedCount,
+		ReplacedCount:      outscript.replacedCount,
+		IsActiveNode:       isSchedulerActive(),
+	}
+}
+
+// isSchedulerActive tells whether this node should run scheduled processes: the raft
+// leader, or any node when raft is not in use. With raft configured, no node is active
+// until raft is set up and elects it.
+func isSchedulerActive() bool {
+	if config.Config().RaftEnabled {
+		return oraft.IsLeader()
+	}
+	return true
+}
+
+// onActivation starts a run, or applies the overlap policy if the previous run is still active
+func (outscript *OutScripts) onActivation() {
+	outscript.mutex.Lock()
+	defer outscript.mutex.Unlock()
+
+	if !outscript.running {
+		outscript.startRun()
+		return
+	}
+	switch outscript.OverlapPolicy {
+	case config.OverlapPolicyQueue:
+		if outscript.pending {
+			outscript.skippedCount++
+			log.Warningf("scheduled process %s: previous run still active and a run is already queued; skipping activation (skipped %d times)", outscript.Key, outscript.skippedCount)
+			return
+		}
+		outscript.pending = true
+		outscript.queuedCount++
+		log.Warningf("scheduled process %s: previous run still active; queuing activation (queued %d times)", outscript.Key, outscript.queuedCount)
+	case config.OverlapPolicyReplace:
+		outscript.pending = true
+		outscript.replacedCount++
+		log.Warningf("scheduled process %s: previous run still active; canceling it in favor of a new run (replaced %d times)", outscript.Key, outscript.replacedCount)
+		outscript.cancelRun()
+	default:
+		outscript.skippedCount++
+		log.Warningf("scheduled process %s: previous run still active; skipping activation (skipped %d times)", outscript.Key, outscript.skippedCount)
+	}
+}
+
+// startRun runs the command in the background, once the process is below its concurrency
+// limit. When done, a pending run, if any, is started. The run is canceled should this node
+// stop being the active node.
+// Must be called with the mutex held.
+func (outscript *OutScripts) startRun() {
+	ctx, cancel := util.CommandContext(0)
+	outscript.running = true
+	outscript.cancelRun = cancel
+	outscript.lastRunTime = time.Now()
+	outscript.runCount++
+
+	go func() {
+		defer cancel()
+		go outscript.cancelOnLostLeadership(ctx, cancel)
+		audit := NewAuditEntry(outscript.process, AuditTriggerSchedule, "", "", nil)
+		if err := outscript.runCommand(ctx, audit); err != nil {
+			log.Errorf("run cmd %s failed: %s", util.Redact(outscript.Script), err.Error())
+		}
+
+		outscript.mutex.Lock()
+		defer outscript.mutex.Unlock()
+		outscript.running = false
+		outscript.cancelRun = nil
+		if outscript.pending {
+			outscript.pending = false
+			if isSchedulerActive() {
+				outscript.startRun()
+			}
+		}
+	}()
+}
+
+// runCommand waits for a free slot of the process, then runs its command bounded by the process
+// timeout and holding its cluster lock, if any. Failed runs are retried as the process retry
+// policy allows, until the run is canceled, e.g. because this node lost leadership.
+func (outscript *OutScripts) runCommand(ctx context.Context, audit *AuditEntry) error {
+	release, err := acquireProcessSlot(ctx, outscript.process)
+	if err != nil {
+		auditExecution(audit, nil, err)
+		return err
+	}
+	defer release()
+	if ctx.Err() != nil {
+		auditExecution(audit, nil, util.ErrCommandCanceled)
+		return util.ErrCommandCanceled
+	}
+	command, err := NewProcessCommand(outscript.process, nil)
+	if err != nil {
+		auditExecution(audit, nil, err)
+		return err
+	}
+	_, err = runProcessAttempts(ctx, outscript.process, command, audit)
+	return err
+}
+
+// cancelOnLostLeadership cancels a run once this node is no longer the active node, so that
+// it does not overlap with a run started by the new leader
+func (outscript *OutScripts) cancelOnLostLeadership(ctx context.Context, cancel context.CancelFunc) {
+	ticker := time.NewTicker(config.HealthPollSeconds * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !isSchedulerActive() {
+				log.Warningf("scheduled process %s: this node is no longer active; canceling run", outscript.Key)
+				cancel()
+				return
+			}
+		}
+	}
+}
+
+// RunOutScript activates the scheduled process at each fire time, for as long as this node
+// is the active one
+func RunOutScript(outscript *OutScripts) {
+	for {
+		nextRunTime := outscript.computeNextRunTime(time.Now())
+		if nextRunTime.IsZero() {
+			log.Errorf("scheduled process %s: cron expression %s never fires; not scheduling", outscript.Key, outscript.Cron)
+			return
+		}
+		outscript.mutex.Lock()
+		outscript.nextRunTime = nextRunTime
+		outscript.mutex.Unlock()
+
+		timer := time.NewTimer(time.Until(nextRunTime))
+		select {
+		case <-outscript.stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if !isSchedulerActive() {
+			continue
+		}
+		outscript.onActivation()
+	}
+}
+
+// unschedule stops further activations of the scheduled process. A run in progress is
+// allowed to complete, but a pending one is dropped.
+func (outscript *OutScripts) unschedule() {
+	close(outscript.stop)
+	outscript.mutex.Lock()
+	defer outscript.mutex.Unlock()
+	outscript.pending = false
+}
+
+// ScheduleProcesses starts running all processes which have an interval or a cron expression.
+// On reload, processes whose definition is unchanged keep their running schedule, while
+// changed and removed ones are unscheduled.
+func ScheduleProcesses(processes []*config.Process) {
+	scheduledScriptsMutex.Lock()
+	defer scheduledScriptsMutex.Unlock()
+
+	previouslyScheduled := make(map[string]*OutScripts)
+	for _, outscript := range scheduledScripts {
+		previouslyScheduled[outscript.Key] = outscript
+	}
+	scheduled := []*OutScripts{}
+	for _, proc := range processes {
+		if !proc.IsScheduled() {
+			continue
+		}
+		if outscript, found := previouslyScheduled[proc.Key]; found && outscript.process.Equals(proc) {
+			delete(previouslyScheduled, proc.Key)
+			scheduled = append(scheduled, outscript)
+			continue
+		}
+		outScripts, err := NewOutScripts(proc)
+		if err != nil {
+			log.Errorf("cannot schedule process %s: %+v", proc.Key, err)
+			continue
+		}
+		scheduled = append(scheduled, outScripts)
+		go RunOutScript(outScripts)
+		log.Infof("scheduled process %s", proc.Key)
+	}
+	for _, outscript := range previouslyScheduled {
+		outscript.unschedule()
+		log.Infof("unscheduled process %s", outscript.Key)
+	}
+	scheduledScripts = scheduled
+}
+
+// ScheduledProcesses lists all scheduled processes, by their next run time
+func ScheduledProcesses() (statuses []ScheduleStatus) {
+	scheduledScriptsMutex.Lock()
+	defer scheduledScriptsMutex.Unlock()
+
+	for _, outscript := range scheduledScripts {
+		statuses = append(statuses, outscript.status())
+	}
+	sort.SliceStable(statuses, func(i, j int) bool {
+		return statuses[i].NextRunTime.Before(statuses[j].NextRunTime)
+	})
+	return statuses
+}
+
+// scheduledProcessStatus returns the schedule of given process, or nil when it is not scheduled
+func scheduledProcessStatus(key string) *ScheduleStatus {
+	scheduledScriptsMutex.Lock()
+	defer scheduledScriptsMutex.Unlock()
+
+	for _, outscript := range scheduledScripts {
+		if outscript.Key == key {
+			status := outscript.status()
+			return &status
+		}
+	}
+	return nil
+}
@@ -1,10 +1,17 @@
 package logic
 
 import (
-	//"bytes"
+	"encoding/json"
 	"io"
 )
 
+// SnapshotData is the replicated state persisted in raft snapshots
+type SnapshotData struct {
+	ProcessRegistry *ProcessRegistry
+	ClusterLocks    [](*ClusterLock)
+	KVEntries       [](*KVEntry)
+}
+
 type SnapshotDataCreatorApplier struct {
 }
 
@@ -14,9 +21,26 @@ func NewSnapshotDataCreatorApplier() *SnapshotDataCreatorApplier {
 }
 
 func (this *SnapshotDataCreatorApplier) GetData() (data []byte, err error) {
-	return data, nil
+	snapshotData := &SnapshotData{
+		ProcessRegistry: ReadProcessRegistry(),
+		ClusterLocks:    snapshotClusterLocks(),
+		KVEntries:       snapshotKVEntries(),
+	}
+	return json.Marshal(snapshotData)
 }
 
-func (this *SnapshotDataCreatorApplier) Restore(rc io.ReadCloser) error {
-	return nil
+func (this *SnapshotDataCreatorApplier) Restore(rc io.ReadCloser, index uint64) error {
+	snapshotData := &SnapshotData{}
+	if err := json.NewDecoder(rc).Decode(snapshotData); err != nil {
+		if err == io.EOF {
+			// Snapshot taken before any state was replicated
+			restoreClusterLocks(nil)
+			restoreKVEntries(nil, index)
+			return restoreProcessRegistry(nil)
+		}
+		return err
+	}
+	restoreClusterLocks(snapshotData.ClusterLocks)
+	restoreKVEntries(snapshotData.KVEntries, index)
+	return restoreProcessRegistry(snapshotData.ProcessRegistry)
 }
@@ -0,0 +1,173 @@
+package logic
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/github/my-manager/raft"
+)
+
+// maxWatchedChanges bounds the recent key changes kept for watches. A watch from an index
+// older than the oldest kept change returns at once, for the client to read afresh.
+const maxWatchedChanges = 10000
+
+// kvChange records the raft index at which a key was set, deleted or expired
+type kvChange struct {
+	Key   string
+	Index uint64
+}
+
+// stateWatches follows changes to replicated state as the FSM applies them on this node, be it
+// the leader or a follower, and wakes up watches waiting on them
+type stateWatches struct {
+	mutex          sync.Mutex
+	appliedIndex   uint64
+	leaderURIIndex uint64
+	changes        []kvChange // In index order
+	floorIndex     uint64     // Changes at or below this index may not be kept
+	applied        chan bool  // Closed, and replaced, whenever a command is applied
+}
+
+var watches = &stateWatches{applied: make(chan bool)}
+
+// WatchResult is the outcome of a watch. Index is to be passed to the next watch.
+type WatchResult struct {
+	Index     uint64
+	Changed   bool
+	Entries   [](*KVEntry) `json:",omitempty"`
+	LeaderURI string       `json:",omitempty"`
+}
+
+// onApplied is called by the FSM apply path once a command is applied, and wakes up watches
+func (this *stateWatches) onApplied(index uint64) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if index > this.appliedIndex {
+		this.appliedIndex = index
+	}
+	close(this.applied)
+	this.applied = make(chan bool)
+}
+
+// keyChanged records a change of a key, applied at given index
+func (this *stateWatches) keyChanged(key string, index uint64) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.changes = append(this.changes, kvChange{Key: key, Index: index})
+	if len(this.changes) > maxWatchedChanges {
+		dropped := len(this.changes) - maxWatchedChanges
+		this.floorIndex = this.changes[dropped-1].Index
+		this.changes = append([]kvChange{}, this.changes[dropped:]...)
+	}
+}
+
+// leaderURIChanged records a change of the leader URI, applied at given index
+func (this *stateWatches) leaderURIChanged(index uint64) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.leaderURIIndex = index
+}
+
+// reset forgets recorded changes when state is restored from a snapshot taken at given index,
+// so that watches from any index up to it return at once. Raft replays later entries from there.
+func (this *stateWatches) reset(restoredIndex uint64) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.appliedIndex = restoredIndex
+	this.floorIndex = this.appliedIndex
+	this.leaderURIIndex = this.appliedIndex
+	this.changes = []kvChange{}
+	close(this.applied)
+	this.applied = make(chan bool)
+}
+
+// changedSince tells whether a key matching given function changed after given index. It also
+// returns the applied index, and a channel closed upon the next applied command.
+func (this *stateWatches) changedSince(afterIndex uint64, matches func(key string) bool) (changed bool, index uint64, applied chan bool) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if afterIndex < this.floorIndex {
+		return true, this.appliedIndex, this.applied
+	}
+	for i := len(this.changes) - 1; i >= 0 && this.changes[i].Index > afterIndex; i-- {
+		if matches(this.changes[i].Key) {
+			return true, this.appliedIndex, this.applied
+		}
+	}
+	return false, this.appliedIndex, this.applied
+}
+
+// wait blocks until changed reports a change after given index, the timeout expires, or ctx is done.
+// An afterIndex of 0 returns at once. An afterIndex beyond this node's applied index, as seen from
+// another node or before a restart, is clamped to the applied index: the watch then wakes up on
+// the next change applied here, and an unchanged result keeps reporting the given index.
+func (this *stateWatches) wait(ctx context.Context, afterIndex uint64, timeout time.Duration, changed func(afterIndex uint64) (bool, uint64, chan bool)) (bool, uint64) {
+	if afterIndex == 0 {
+		_, index, _ := changed(afterIndex)
+		return true, index
+	}
+	this.mutex.Lock()
+	watchIndex := afterIndex
+	if watchIndex > this.appliedIndex {
+		watchIndex = this.appliedIndex
+	}
+	this.mutex.Unlock()
+
+	unchangedIndex := func(index uint64) uint64 {
+		if index < afterIndex {
+			return afterIndex
+		}
+		return index
+	}
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		isChanged, index, applied := changed(watchIndex)
+		if isChanged {
+			return true, index
+		}
+		select {
+		case <-applied:
+		case <-deadline.C:
+			return false, unchangedIndex(index)
+		case <-ctx.Done():
+			return false, unchangedIndex(index)
+		}
+	}
+}
+
+// WatchKV blocks until given key, or any key beginning with it when prefix is set, is set,
+// deleted or expires at an index beyond afterIndex, or until the timeout expires. It returns
+// the matching keys as applied on this node. An afterIndex of 0 returns at once.
+func WatchKV(ctx context.Context, key string, prefix bool, afterIndex uint64, timeout time.Duration) *WatchResult {
+	matches := func(changedKey string) bool {
+		if prefix {
+			return strings.HasPrefix(changedKey, key)
+		}
+		return changedKey == key
+	}
+	changed, index := watches.wait(ctx, afterIndex, timeout, func(afterIndex uint64) (bool, uint64, chan bool) {
+		return watches.changedSince(afterIndex, matches)
+	})
+	result := &WatchResult{Index: index, Changed: changed, Entries: [](*KVEntry){}}
+	if prefix {
+		result.Entries = ReadKVEntries(key)
+	} else if entry, found := ReadKVEntry(key); found {
+		result.Entries = append(result.Entries, entry)
+	}
+	return result
+}
+
+// WatchLeaderURI blocks until the leader URI changes at an index beyond afterIndex, or until
+// the timeout expires. An afterIndex of 0 returns at once.
+func WatchLeaderURI(ctx context.Context, afterIndex uint64, timeout time.Duration) *WatchResult {
+	changed, index := watches.wait(ctx, afterIndex, timeout, func(afterIndex uint64) (bool, uint64, chan bool) {
+		watches.mutex.Lock()
+		defer watches.mutex.Unlock()
+		changed := afterIndex < watches.leaderURIIndex
+		return changed, watches.appliedIndex, watches.applied
+	})
+	return &WatchResult{Index: index, Changed: changed, LeaderURI: oraft.LeaderURI.Get()}
+}
@@ -0,0 +1,237 @@
+package logic
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/github/my-manager/config"
+	"github.com/github/my-manager/raft"
+	"github.com/github/my-manager/util"
+
+	"github.com/openark/golib/log"
+)
+
+const (
+	WebhookStatusPending   = "pending"
+	WebhookStatusDelivered = "delivered"
+	WebhookStatusFailed    = "failed"
+)
+
+const (
+	WebhookIdHeader        = "X-My-Manager-Webhook-Id"
+	WebhookTimestampHeader = "X-My-Manager-Webhook-Timestamp"
+	// WebhookSignatureHeader holds "sha256=" followed by the hex HMAC-SHA256, keyed by
+	// WebhookSecret, of the timestamp header value, a dot, and the request body
+	WebhookSignatureHeader = "X-My-Manager-Webhook-Signature"
+)
+
+const webhookEventJobFinished = "job.finished"
+
+const (
+	webhookInitialBackoff = 2 * time.Second
+	webhookMaxBackoff     = 5 * time.Minute
+)
+
+// maxResumedWebhookDeliveries bounds the deliveries picked up again at startup
+const maxResumedWebhookDeliveries = 1000
+
+// WebhookDelivery records the notification of a job callback URL, as recorded in the
+// webhook_delivery table
+type WebhookDelivery struct {
+	DeliveryId     string
+	JobId          string
+	ProcessKey     string
+	CallbackURL    string
+	Status         string
+	Attempts       int
+	LastStatusCode int
+	LastError      string
+	CreatedAt      string
+	LastAttemptAt  string
+}
+
+// WebhookPayload is the JSON body posted to a callback URL
+type WebhookPayload struct {
+	Event      string
+	DeliveryId string
+	Job        *Job
+}
+
+var webhookClient *http.Client
+var webhookClientMutex sync.Mutex
+
+// getWebhookClient returns the client notifying callback URLs. It shares the TLS setup used
+// for raft communication, and is rebuilt when a reload changes WebhookTimeoutSeconds.
+func getWebhookClient() *http.Client {
+	timeout := time.Duration(config.Config().WebhookTimeoutSeconds) * time.Second
+
+	webhookClientMutex.Lock()
+	defer webhookClientMutex.Unlock()
+	if webhookClient == nil || webhookClient.Timeout != timeout {
+		webhookClient = &http.Client{
+			Transport: oraft.NewHttpTransport(),
+			Timeout:   timeout,
+		}
+	}
+	return webhookClient
+}
+
+// JobCallbackURL returns the URL to notify once an async job of given process finishes: the
+// requested one, or else the process default. It is empty when there is none to notify.
+func JobCallbackURL(proc *config.Process, requestedURL string) (string, error) {
+	callbackURL := requestedURL
+	if callbackURL == "" {
+		callbackURL = proc.CallbackURL
+	}
+	if callbackURL == "" {
+		return "", nil
+	}
+	if err := config.ValidateCallbackURL(callbackURL); err != nil {
+		return "", err
+	}
+	if config.Config().WebhookSecret == "" {
+		return "", fmt.Errorf("cannot notify %s: WebhookSecret is not configured", callbackURL)
+	}
+	return callbackURL, nil
+}
+
+// signWebhook returns the signature of a payload sent at given timestamp
+func signWebhook(timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(config.Config().WebhookSecret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// notifyJobFinished posts the result of a finished job to its callback URL, if it has one.
+// Delivery happens in the background, retrying with backoff up to WebhookMaxAttempts times.
+func notifyJobFinished(job *Job) {
+	if job.CallbackURL == "" {
+		return
+	}
+	delivery := &WebhookDelivery{
+		DeliveryId:  util.NewToken().Hash,
+		JobId:       job.JobId,
+		ProcessKey:  job.ProcessKey,
+		CallbackURL: job.CallbackURL,
+		Status:      WebhookStatusPending,
+	}
+	body, err := webhookBody(job, delivery.DeliveryId)
+	if err != nil {
+		log.Errorf("cannot encode callback of job %s: %+v", job.JobId, err)
+		return
+	}
+	if err := writeWebhookDelivery(delivery); err != nil {
+		log.Errorf("cannot record callback delivery of job %s: %+v", job.JobId, err)
+	}
+	go deliverWebhook(delivery, body)
+}
+
+// webhookBody encodes the payload announcing that given job finished
+func webhookBody(job *Job, deliveryId string) ([]byte, error) {
+	return json.Marshal(&WebhookPayload{Event: webhookEventJobFinished, DeliveryId: deliveryId, Job: job})
+}
+
+// ResumeWebhookDeliveries picks up the callback deliveries which a previous incarnation of this
+// node left pending, so that they are not lost on restart. The attempts made so far count
+// towards WebhookMaxAttempts. Deliveries whose job is gone are marked failed.
+func ResumeWebhookDeliveries() error {
+	deliveries, err := readAbandonedWebhookDeliveries()
+	if err != nil {
+		return err
+	}
+	for _, delivery := range deliveries {
+		job, err := ReadJob(delivery.JobId)
+		var body []byte
+		if err == nil {
+			body, err = webhookBody(job, delivery.DeliveryId)
+		}
+		if err != nil {
+			delivery.Status = WebhookStatusFailed
+			delivery.LastError = fmt.Sprintf("abandoned: cannot resume delivery: %+v", err)
+			updateWebhookDelivery(delivery)
+			continue
+		}
+		log.Infof("resuming callback of job %s to %s after %d attempts", delivery.JobId, delivery.CallbackURL, delivery.Attempts)
+		go deliverWebhook(delivery, body)
+	}
+	return nil
+}
+
+// deliverWebhook posts given body until the callback URL accepts it, the error is permanent,
+// or attempts are exhausted. Every attempt updates the delivery record.
+func deliverWebhook(delivery *WebhookDelivery, body []byte) {
+	maxAttempts := int(config.Config().WebhookMaxAttempts)
+	backoff := webhookInitialBackoff
+	for {
+		delivery.Attempts++
+		retryable, err := postWebhook(delivery, body)
+		delivery.LastError = ""
+		switch {
+		case err == nil:
+			delivery.Status = WebhookStatusDelivered
+		case !retryable || delivery.Attempts >= maxAttempts:
+			delivery.Status = WebhookStatusFailed
+			delivery.LastError = err.Error()
+		default:
+			delivery.LastError = err.Error()
+		}
+		if err := updateWebhookDelivery(delivery); err != nil {
+			log.Errorf("cannot record callback delivery %s of job %s: %+v", delivery.DeliveryId, delivery.JobId, err)
+		}
+		if delivery.Status != WebhookStatusPending {
+			if delivery.Status == WebhookStatusFailed {
+				log.Errorf("giving up on callback of job %s to %s after %d attempts: %s", delivery.JobId, delivery.CallbackURL, delivery.Attempts, delivery.LastError)
+			}
+			return
+		}
+		log.Warningf("callback of job %s to %s failed: %s; retrying in %+v", delivery.JobId, delivery.CallbackURL, delivery.LastError, backoff)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > webhookMaxBackoff {
+			backoff = webhookMaxBackoff
+		}
+	}
+}
+
+// postWebhook makes a single delivery attempt. Failures are retryable unless the callback URL
+// rejected the request with a client error other than 408 or 429.
+func postWebhook(delivery *WebhookDelivery, body []byte) (retryable bool, err error) {
+	req, err := http.NewRequest("POST", delivery.CallbackURL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookIdHeader, delivery.DeliveryId)
+	req.Header.Set(WebhookTimestampHeader, timestamp)
+	req.Header.Set(WebhookSignatureHeader, signWebhook(timestamp, body))
+
+	res, err := getWebhookClient().Do(req)
+	if err != nil {
+		delivery.LastStatusCode = 0
+		return true, err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+
+	delivery.LastStatusCode = res.StatusCode
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("got %d status", res.StatusCode)
+	if res.StatusCode >= 400 && res.StatusCode < 500 && res.StatusCode != http.StatusRequestTimeout && res.StatusCode != http.StatusTooManyRequests {
+		return false, err
+	}
+	return true, err
+}
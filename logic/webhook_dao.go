@@ -0,0 +1,110 @@
+package logic
+
+import (
+	"fmt"
+
+	"github.com/github/my-manager/config"
+	"github.com/github/my-manager/db"
+	"github.com/github/my-manager/process"
+	"github.com/github/my-manager/util"
+
+	"github.com/openark/golib/log"
+	"github.com/openark/golib/sqlutils"
+)
+
+// writeWebhookDelivery records a new, pending delivery of a job callback
+func writeWebhookDelivery(delivery *WebhookDelivery) error {
+	_, err := db.ExecDb(`
+			insert into webhook_delivery
+				(delivery_id, job_id, process_key, callback_url, status, created_at)
+			values
+				(?, ?, ?, ?, ?, now())
+			`,
+		delivery.DeliveryId, delivery.JobId, delivery.ProcessKey, delivery.CallbackURL, delivery.Status,
+	)
+	return log.Errore(err)
+}
+
+// updateWebhookDelivery records the outcome of a delivery attempt
+func updateWebhookDelivery(delivery *WebhookDelivery) error {
+	_, err := db.ExecDb(`
+			update webhook_delivery set
+				status = ?,
+				attempts = ?,
+				last_status_code = ?,
+				last_error = ?,
+				last_attempt_at = now()
+			where
+				delivery_id = ?
+			`,
+		delivery.Status, delivery.Attempts, delivery.LastStatusCode, delivery.LastError, delivery.DeliveryId,
+	)
+	return log.Errore(err)
+}
+
+// readWebhookDeliveries reads callback deliveries matching given condition, most recent first
+func readWebhookDeliveries(whereCondition string, args []interface{}, limit uint) (deliveries [](*WebhookDelivery), err error) {
+	query := fmt.Sprintf(`
+		select
+			delivery_id, job_id, process_key, callback_url, status, attempts, last_status_code,
+			ifnull(last_error, '') as last_error,
+			created_at,
+			ifnull(last_attempt_at, '') as last_attempt_at
+		from
+			webhook_delivery
+		%s
+		order by
+			created_at desc, delivery_id
+		limit %d
+		`, whereCondition, limit)
+	err = db.QueryDB(query, args, func(m sqlutils.RowMap) error {
+		deliveries = append(deliveries, &WebhookDelivery{
+			DeliveryId:     m.GetString("delivery_id"),
+			JobId:          m.GetString("job_id"),
+			ProcessKey:     m.GetString("process_key"),
+			CallbackURL:    m.GetString("callback_url"),
+			Status:         m.GetString("status"),
+			Attempts:       m.GetInt("attempts"),
+			LastStatusCode: m.GetInt("last_status_code"),
+			LastError:      m.GetString("last_error"),
+			CreatedAt:      m.GetString("created_at"),
+			LastAttemptAt:  m.GetString("last_attempt_at"),
+		})
+		return nil
+	})
+	return deliveries, log.Errore(err)
+}
+
+// ReadWebhookDeliveries returns the most recent callback deliveries, optionally of a given job
+func ReadWebhookDeliveries(jobId string, limit uint) ([](*WebhookDelivery), error) {
+	if jobId == "" {
+		return readWebhookDeliveries("", sqlutils.Args(), limit)
+	}
+	return readWebhookDeliveries("where job_id = ?", sqlutils.Args(jobId), limit)
+}
+
+// readAbandonedWebhookDeliveries returns the pending callback deliveries of jobs run by a
+// previous incarnation of this node, which stopped before delivering them
+func readAbandonedWebhookDeliveries() ([](*WebhookDelivery), error) {
+	return readWebhookDeliveries(`
+		where
+			status = ?
+			and job_id in (
+				select job_id from process_job where hostname = ? and token != ?
+			)
+		`, sqlutils.Args(WebhookStatusPending, process.ThisHostname, util.ProcessToken.Hash), maxResumedWebhookDeliveries)
+}
+
+// ExpireWebhookDeliveries purges delivery records along with the jobs they belong to, after
+// ProcessJobExpireHours. It is run by the active node.
+func ExpireWebhookDeliveries() error {
+	_, err := db.ExecDb(`
+			delete
+				from webhook_delivery
+			where
+				created_at < now() - interval ? hour
+			`,
+		config.Config().ProcessJobExpireHours,
+	)
+	return log.Errore(err)
+}
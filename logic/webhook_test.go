@@ -0,0 +1,83 @@
+package logic
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/github/my-manager/config"
+)
+
+func TestSignWebhook(t *testing.T) {
+	config.Config().WebhookSecret = "webhook-secret"
+	defer func() { config.Config().WebhookSecret = "" }()
+
+	signature := signWebhook("1700000000", []byte(`{"Event":"job.finished"}`))
+	if expected := "sha256=0319c7e52521dc4a53d56ebcf294aed221b9bf45827c5a19c72fa5c99edda60d"; signature != expected {
+		t.Errorf("expected signature %s, got %s", expected, signature)
+	}
+	if other := signWebhook("1700000001", []byte(`{"Event":"job.finished"}`)); other == signature {
+		t.Errorf("expected the timestamp to be signed")
+	}
+}
+
+func TestPostWebhook(t *testing.T) {
+	config.Config().WebhookSecret = "webhook-secret"
+	defer func() { config.Config().WebhookSecret = "" }()
+
+	var status int
+	var received *http.Request
+	var receivedBody []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		receivedBody, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	body := []byte(`{"Event":"job.finished","DeliveryId":"d1"}`)
+	delivery := &WebhookDelivery{DeliveryId: "d1", CallbackURL: server.URL}
+
+	status = http.StatusNoContent
+	if retryable, err := postWebhook(delivery, body); err != nil || retryable {
+		t.Fatalf("expected delivery, got retryable %t, error %+v", retryable, err)
+	}
+	if string(receivedBody) != string(body) || received.Header.Get(WebhookIdHeader) != "d1" {
+		t.Errorf("unexpected request: %s with headers %+v", receivedBody, received.Header)
+	}
+	timestamp := received.Header.Get(WebhookTimestampHeader)
+	if signature := received.Header.Get(WebhookSignatureHeader); signature != signWebhook(timestamp, body) {
+		t.Errorf("signature %s does not match timestamp %s and body", signature, timestamp)
+	}
+
+	// Server errors, timeouts and throttling are worth retrying; other client errors are not
+	retryableStatuses := map[int]bool{
+		http.StatusBadRequest:          false,
+		http.StatusUnauthorized:        false,
+		http.StatusNotFound:            false,
+		http.StatusGone:                false,
+		http.StatusRequestTimeout:      true,
+		http.StatusTooManyRequests:     true,
+		http.StatusInternalServerError: true,
+		http.StatusBadGateway:          true,
+		http.StatusServiceUnavailable:  true,
+	}
+	for status = range retryableStatuses {
+		retryable, err := postWebhook(delivery, body)
+		if err == nil {
+			t.Errorf("status %d: expected an error", status)
+		}
+		if retryable != retryableStatuses[status] {
+			t.Errorf("status %d: expected retryable %t", status, retryableStatuses[status])
+		}
+		if delivery.LastStatusCode != status {
+			t.Errorf("status %d: recorded status %d", status, delivery.LastStatusCode)
+		}
+	}
+
+	server.Close()
+	if retryable, err := postWebhook(delivery, body); err == nil || !retryable || delivery.LastStatusCode != 0 {
+		t.Errorf("expected a retryable connection failure, got retryable %t, error %+v, status %d", retryable, err, delivery.LastStatusCode)
+	}
+}
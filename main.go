@@ -14,6 +14,8 @@ func main() {
 	verbose := flag.Bool("verbose", false, "verbose")
 	debug := flag.Bool("debug", false, "debug mode (very verbose)")
 	stack := flag.Bool("stack", false, "add stack trace upon error")
+	node := flag.String("node", "", "base URI of the node CLI commands are sent to (default: this host's advertised URI)")
+	user := flag.String("user", "", "user CLI commands identify as, with proxy authentication (default: RaftNodeAuthUser)")
 	flag.Parse()
 
 	log.SetLevel(log.ERROR)
@@ -41,9 +43,16 @@ func main() {
 			"conf/my-manager.conf.json",
 			"my-manager.conf.json")
 	}
-	if config.Config.Debug {
+	if config.Config().Debug {
 		log.SetLevel(log.DEBUG)
 	}
 	config.MarkConfigurationLoaded()
-	app.Http()
+	if command := flag.Arg(0); app.IsCliCommand(command) {
+		app.Cli(command, *node, *user, flag.Args()[1:])
+	} else {
+		if command != "" && command != "http" {
+			log.Warningf("Ignoring unknown command %s; run `my-manager help` for CLI commands", command)
+		}
+		app.Http()
+	}
 }
@@ -86,7 +86,8 @@ func AttemptElection() (bool, error) {
 }
 
 // ElectedNode returns the details of the elected node, as well as answering the question "is this process the elected one"?
-func ElectedNode() (node NodeHealth, isElected bool, err error) {
+func ElectedNode() (node *NodeHealth, isElected bool, err error) {
+	node = &NodeHealth{}
 	query := `
 		select
 			hostname,
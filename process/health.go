@@ -54,7 +54,7 @@ type HealthStatus struct {
 	Hostname           string
 	Token              string
 	IsActiveNode       bool
-	ActiveNode         NodeHealth
+	ActiveNode         *NodeHealth
 	Error              error
 	AvailableNodes     [](*NodeHealth)
 	RaftLeader         string
@@ -100,12 +100,12 @@ func HealthTest() (health *HealthStatus, err error) {
 	}
 
 	if oraft.IsRaftEnabled() {
-		health.ActiveNode.Hostname = oraft.GetLeader()
+		health.ActiveNode = &NodeHealth{Hostname: oraft.GetLeader()}
 		health.IsActiveNode = oraft.IsLeader()
 		health.RaftLeader = oraft.GetLeader()
 		health.RaftLeaderURI = oraft.LeaderURI.Get()
 		health.IsRaftLeader = oraft.IsLeader()
-		health.RaftAdvertise = config.Config.RaftAdvertise
+		health.RaftAdvertise = config.Config().RaftAdvertise
 		health.RaftHealthyMembers = oraft.HealthyMembers()
 	} else {
 		if health.ActiveNode, health.IsActiveNode, err = ElectedNode(); err != nil {
@@ -68,14 +68,14 @@ func WriteRegisterNode(nodeHealth *NodeHealth) (healthy bool, err error) {
 		ip := ""
 		dbBackend := ""
 		raftPort := ""
-		dbBackend = fmt.Sprintf("%s:%d", config.Config.BackendDbHosts,
-			config.Config.BackendDbPort)
-		hostPort := strings.Split(config.Config.RaftBind, ":")
+		dbBackend = fmt.Sprintf("%s:%d", config.Config().BackendDbHosts,
+			config.Config().BackendDbPort)
+		hostPort := strings.Split(config.Config().RaftBind, ":")
 		if len(hostPort) > 1 {
 			raftPort = hostPort[1]
 		} else {
-			if config.Config.DefaultRaftPort != 0 {
-				raftPort = fmt.Sprintf("%d", config.Config.DefaultRaftPort)
+			if config.Config().DefaultRaftPort != 0 {
+				raftPort = fmt.Sprintf("%d", config.Config().DefaultRaftPort)
 			}
 		}
 
@@ -140,7 +140,7 @@ func ExpireNodesHistory() error {
 			where
 				first_seen_active < now() - interval ? hour
 			`,
-		config.Config.UnseenInstanceForgetHours,
+		config.Config().UnseenInstanceForgetHours,
 	)
 	return log.Errore(err)
 }
@@ -1,5 +1,6 @@
 package oraft
 
 type CommandApplier interface {
-	ApplyCommand(op string, value []byte) interface{}
+	// ApplyCommand applies a command on this node. index is the raft log index of the command.
+	ApplyCommand(op string, value []byte, index uint64) interface{}
 }
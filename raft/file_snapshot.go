@@ -57,10 +57,12 @@ type fileSnapshotMeta struct {
 }
 
 // bufferedFile is returned when we open a snapshot. This way
-// reads are buffered and the file still gets closed.
+// reads are buffered and the file still gets closed. It carries the
+// index of the snapshot for the FSM restore to learn it.
 type bufferedFile struct {
-	bh *bufio.Reader
-	fh *os.File
+	bh    *bufio.Reader
+	fh    *os.File
+	index uint64
 }
 
 func (b *bufferedFile) Read(p []byte) (n int, err error) {
@@ -315,8 +317,9 @@ func (f *FileSnapshotStore) Open(id string) (*raft.SnapshotMeta, io.ReadCloser,
 
 	// Return a buffered file
 	buffered := &bufferedFile{
-		bh: bufio.NewReader(fh),
-		fh: fh,
+		bh:    bufio.NewReader(fh),
+		fh:    fh,
+		index: meta.Index,
 	}
 
 	return &meta.SnapshotMeta, buffered, nil
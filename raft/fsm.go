@@ -32,7 +32,7 @@ func (f *fsm) Apply(l *raft.Log) interface{} {
 		return f.yieldByHint(hint)
 	}
 	log.Debugf("oraft: applying command %+v: %s", l.Index, c.Op)
-	return store.applier.ApplyCommand(c.Op, c.Value)
+	return store.applier.ApplyCommand(c.Op, c.Value, l.Index)
 }
 
 // yield yields to a suggested peer, or does nothing if this peer IS the suggested peer
@@ -66,13 +66,16 @@ func (f *fsm) yieldByHint(hint string) interface{} {
 
 // Snapshot returns a snapshot object of freno's state
 func (f *fsm) Snapshot() (raft.FSMSnapshot, error) {
-	snapshot := newFsmSnapshot(f.snapshotCreatorApplier)
-	return snapshot, nil
+	return newFsmSnapshot(f.snapshotCreatorApplier)
 }
 
 // Restore restores freno state
 func (f *fsm) Restore(rc io.ReadCloser) error {
 	defer rc.Close()
 
-	return f.snapshotCreatorApplier.Restore(rc)
+	var index uint64
+	if snapshot, ok := rc.(*bufferedFile); ok {
+		index = snapshot.index
+	}
+	return f.snapshotCreatorApplier.Restore(rc, index)
 }
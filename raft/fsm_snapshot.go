@@ -4,24 +4,25 @@ import (
 	"github.com/hashicorp/raft"
 )
 
-// fsmSnapshot handles raft persisting of snapshots
+// fsmSnapshot handles raft persisting of snapshots. The data is captured when the snapshot is
+// taken, on the FSM goroutine, so that it reflects exactly the entries applied up to the
+// snapshot index; Persist runs later, concurrently with Apply, and only writes it out.
 type fsmSnapshot struct {
-	snapshotCreatorApplier SnapshotCreatorApplier
+	data []byte
 }
 
-func newFsmSnapshot(snapshotCreatorApplier SnapshotCreatorApplier) *fsmSnapshot {
-	return &fsmSnapshot{
-		snapshotCreatorApplier: snapshotCreatorApplier,
+func newFsmSnapshot(snapshotCreatorApplier SnapshotCreatorApplier) (*fsmSnapshot, error) {
+	data, err := snapshotCreatorApplier.GetData()
+	if err != nil {
+		return nil, err
 	}
+	return &fsmSnapshot{data: data}, nil
 }
 
 // Persist
 func (f *fsmSnapshot) Persist(sink raft.SnapshotSink) error {
-	data, err := f.snapshotCreatorApplier.GetData()
-	if err != nil {
-		return err
-	}
-	if _, err := sink.Write(data); err != nil {
+	if _, err := sink.Write(f.data); err != nil {
+		sink.Cancel()
 		return err
 	}
 	return sink.Close()
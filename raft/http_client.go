@@ -16,44 +16,53 @@ import (
 )
 
 var httpClient *http.Client
+var clientTLSConfig *tls.Config
 
-func setupHttpClient() error {
+// SetupHttpClient prepares the TLS setup and client used to talk to peer nodes
+func SetupHttpClient() error {
 	httpTimeout := time.Duration(config.ActiveNodeExpireSeconds) * time.Second
-	dialTimeout := func(network, addr string) (net.Conn, error) {
-		return net.DialTimeout(network, addr, httpTimeout)
-	}
 
 	tlsConfig := &tls.Config{
-		InsecureSkipVerify: config.Config.SSLSkipVerify,
+		InsecureSkipVerify: config.Config().SSLSkipVerify,
 	}
-	if config.Config.UseSSL {
-		caPool, err := ssl.ReadCAFile(config.Config.SSLCAFile)
+	if config.Config().UseSSL {
+		caPool, err := ssl.ReadCAFile(config.Config().SSLCAFile)
 		if err != nil {
 			return err
 		}
 		tlsConfig.RootCAs = caPool
 
-		if config.Config.UseMutualTLS {
+		if config.Config().UseMutualTLS {
 			var sslPEMPassword []byte
-			if ssl.IsEncryptedPEM(config.Config.SSLPrivateKeyFile) {
-				sslPEMPassword = ssl.GetPEMPassword(config.Config.SSLPrivateKeyFile)
+			if ssl.IsEncryptedPEM(config.Config().SSLPrivateKeyFile) {
+				sslPEMPassword = ssl.GetPEMPassword(config.Config().SSLPrivateKeyFile)
 			}
-			if err := ssl.AppendKeyPairWithPassword(tlsConfig, config.Config.SSLCertFile, config.Config.SSLPrivateKeyFile, sslPEMPassword); err != nil {
+			if err := ssl.AppendKeyPairWithPassword(tlsConfig, config.Config().SSLCertFile, config.Config().SSLPrivateKeyFile, sslPEMPassword); err != nil {
 				return err
 			}
 		}
 	}
+	clientTLSConfig = tlsConfig
 
-	httpTransport := &http.Transport{
-		TLSClientConfig:       tlsConfig,
-		Dial:                  dialTimeout,
-		ResponseHeaderTimeout: httpTimeout,
-	}
+	httpTransport := NewHttpTransport()
+	httpTransport.ResponseHeaderTimeout = httpTimeout
 	httpClient = &http.Client{Transport: httpTransport}
 
 	return nil
 }
 
+// NewHttpTransport returns a transport for talking to peer nodes, sharing the TLS setup
+// used for raft communication. It does not limit the time to wait for a response.
+func NewHttpTransport() *http.Transport {
+	httpTimeout := time.Duration(config.ActiveNodeExpireSeconds) * time.Second
+	dialTimeout := func(network, addr string) (net.Conn, error) {
+		return net.DialTimeout(network, addr, httpTimeout)
+	}
+	return &http.Transport{
+		TLSClientConfig: clientTLSConfig,
+		Dial:            dialTimeout,
+	}
+}
 
 func HttpGetLeader(path string) (response []byte, err error) {
 	leaderURI := LeaderURI.Get()
@@ -61,18 +70,20 @@ func HttpGetLeader(path string) (response []byte, err error) {
 		return nil, fmt.Errorf("Raft leader URI unknown")
 	}
 	leaderAPI := leaderURI
-	if config.Config.URLPrefix != "" {
+	if config.Config().URLPrefix != "" {
 		// We know URLPrefix begind with "/"
-		leaderAPI = fmt.Sprintf("%s%s", leaderAPI, config.Config.URLPrefix)
+		leaderAPI = fmt.Sprintf("%s%s", leaderAPI, config.Config().URLPrefix)
 	}
 	leaderAPI = fmt.Sprintf("%s/api", leaderAPI)
 
 	url := fmt.Sprintf("%s/%s", leaderAPI, path)
 
 	req, err := http.NewRequest("GET", url, nil)
-	switch strings.ToLower(config.Config.AuthenticationMethod) {
+	switch strings.ToLower(config.Config().AuthenticationMethod) {
 	case "basic", "multi":
-		req.SetBasicAuth(config.Config.HTTPAuthUser, config.Config.HTTPAuthPassword)
+		req.SetBasicAuth(config.Config().HTTPAuthUser, config.Config().HTTPAuthPassword)
+	case "proxy":
+		req.Header.Set(config.Config().AuthUserHeader, config.Config().RaftNodeAuthUser)
 	}
 
 	res, err := httpClient.Do(req)
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -76,26 +77,115 @@ func Yield() error {
 	return getRaft().Yield()
 }
 
+// LeadershipTransfer reports the outcome of a leadership transfer
+type LeadershipTransfer struct {
+	PreviousLeader string
+	Leader         string
+	LeaderURI      string
+	Seconds        float64 // Time it took for the new leader to be confirmed
+}
+
+// YieldToPeer has the leader, and all other peers but given one, refrain from leadership for a
+// short while, so that given peer takes over. It must run on the leader, and waits until a new
+// leader is confirmed or the timeout expires.
+func YieldToPeer(addr string, timeout time.Duration) (*LeadershipTransfer, error) {
+	if !IsRaftEnabled() {
+		return nil, RaftNotRunning
+	}
+	peer, err := normalizeRaftNode(strings.TrimSpace(addr))
+	if err != nil {
+		return nil, err
+	}
+	peers, err := GetPeers()
+	if err != nil {
+		return nil, err
+	}
+	if !raft.PeerContained(peers, peer) {
+		return nil, fmt.Errorf("%s is not a raft peer", peer)
+	}
+	if peer == store.raftAdvertise {
+		return nil, fmt.Errorf("%s is already the leader", peer)
+	}
+	transfer, err := yieldLeadership(YieldCommand, peer, timeout)
+	if err == nil && transfer.Leader != peer {
+		err = fmt.Errorf("leadership was taken by %s rather than %s", transfer.Leader, peer)
+	}
+	return transfer, err
+}
+
+// YieldToHint has the leader, and all other peers whose hostname does not contain given hint,
+// refrain from leadership for a short while, so that a host matching the hint takes over. It
+// must run on the leader, and waits until a new leader is confirmed or the timeout expires.
+func YieldToHint(hint string, timeout time.Duration) (*LeadershipTransfer, error) {
+	if !IsRaftEnabled() {
+		return nil, RaftNotRunning
+	}
+	hint = strings.TrimSpace(hint)
+	if hint == "" {
+		return nil, fmt.Errorf("empty hostname hint")
+	}
+	if strings.Contains(ThisHostname, hint) {
+		return nil, fmt.Errorf("the leader, %s, already matches hint %s", ThisHostname, hint)
+	}
+	return yieldLeadership(YieldHintCommand, hint, timeout)
+}
+
+// yieldLeadership publishes a yield command and waits for another node to be confirmed leader,
+// which is once it has published its URI
+func yieldLeadership(op string, value string, timeout time.Duration) (*LeadershipTransfer, error) {
+	if !IsLeader() {
+		return nil, fmt.Errorf("not leader")
+	}
+	transfer := &LeadershipTransfer{PreviousLeader: GetLeader()}
+	startTime := time.Now()
+	// The value is published raw, as the FSM reads it
+	if _, err := store.genericCommand(op, []byte(value)); err != nil {
+		return nil, err
+	}
+	log.Infof("raft: yielding leadership by %s: %s", op, value)
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	deadline := time.After(timeout)
+	for {
+		select {
+		case <-ticker.C:
+			leader := GetLeader()
+			if leader != "" && leader != transfer.PreviousLeader && !LeaderURI.IsThisLeaderURI() {
+				transfer.Leader = leader
+				transfer.LeaderURI = LeaderURI.Get()
+				transfer.Seconds = time.Since(startTime).Seconds()
+				log.Infof("raft: leadership transferred from %s to %s", transfer.PreviousLeader, transfer.Leader)
+				return transfer, nil
+			}
+		case <-deadline:
+			transfer.Leader = GetLeader()
+			transfer.Seconds = time.Since(startTime).Seconds()
+			return transfer, fmt.Errorf("no new leader confirmed within %+v; leader is %q", timeout, transfer.Leader)
+		}
+	}
+}
+
 // getRaft is a convenience method
 func getRaft() *raft.Raft {
 	return store.raft
 }
 
 func computeLeaderURI() (uri string, err error) {
-	if config.Config.HTTPAdvertise != "" {
+	if config.Config().HTTPAdvertise != "" {
 		// Explicitly given
-		return config.Config.HTTPAdvertise, nil
+		return config.Config().HTTPAdvertise, nil
 	}
 	// Not explicitly given. Let's heuristically compute using RaftAdvertise
 	scheme := "http"
-	if config.Config.UseSSL {
+	if config.Config().UseSSL {
 		scheme = "https"
 	}
 
-	hostname := strings.Split(config.Config.RaftAdvertise, ":")[0]
-	listenTokens := strings.Split(config.Config.ListenAddress, ":")
+	hostname := strings.Split(config.Config().RaftAdvertise, ":")[0]
+	listenTokens := strings.Split(config.Config().ListenAddress, ":")
 	if len(listenTokens) < 2 {
-		return uri, fmt.Errorf("computeLeaderURI: cannot determine listen port out of config.Config.ListenAddress: %+v", config.Config.ListenAddress)
+		return uri, fmt.Errorf("computeLeaderURI: cannot determine listen port out of config.Config().ListenAddress: %+v", config.Config().ListenAddress)
 	}
 	port := listenTokens[1]
 
@@ -103,15 +193,20 @@ func computeLeaderURI() (uri string, err error) {
 	return uri, nil
 }
 
+// ThisNodeURI returns the HTTP URI through which this node is reached, as advertised to peers
+func ThisNodeURI() (string, error) {
+	return computeLeaderURI()
+}
+
 func computeStatusURI() (uri string, err error) {
 	scheme := "http"
-	if config.Config.UseSSL {
+	if config.Config().UseSSL {
 		scheme = "https"
 	}
-	hostname := strings.Split(config.Config.RaftAdvertise, ":")[0]
-	listenTokens := strings.Split(config.Config.ListenAddress, ":")
+	hostname := strings.Split(config.Config().RaftAdvertise, ":")[0]
+	listenTokens := strings.Split(config.Config().ListenAddress, ":")
 	if len(listenTokens) < 2 {
-		return uri, fmt.Errorf("computeStatusURI: cannot determine listen port out of config.Config.ListenAddress: %+v", config.Config.ListenAddress)
+		return uri, fmt.Errorf("computeStatusURI: cannot determine listen port out of config.Config().ListenAddress: %+v", config.Config().ListenAddress)
 	}
 	port := listenTokens[1]
 
@@ -131,17 +226,17 @@ func FatalRaftError(err error) error {
 func Setup(applier CommandApplier, snapshotCreatorApplier SnapshotCreatorApplier, thisHostname string) error {
 	log.Debugf("Setting up raft")
 	ThisHostname = thisHostname
-	raftBind, err := normalizeRaftNode(config.Config.RaftBind)
+	raftBind, err := normalizeRaftNode(config.Config().RaftBind)
 	if err != nil {
 		return err
 	}
-	raftAdvertise, err := normalizeRaftNode(config.Config.RaftAdvertise)
+	raftAdvertise, err := normalizeRaftNode(config.Config().RaftAdvertise)
 	if err != nil {
 		return err
 	}
-	store = NewStore(config.Config.RaftDataDir, raftBind, raftAdvertise, applier, snapshotCreatorApplier)
+	store = NewStore(config.Config().RaftDataDir, raftBind, raftAdvertise, applier, snapshotCreatorApplier)
 	peerNodes := []string{}
-	for _, raftNode := range config.Config.RaftNodes {
+	for _, raftNode := range config.Config().RaftNodes {
 		peerNode, err := normalizeRaftNode(raftNode)
 		if err != nil {
 			return err
@@ -166,12 +261,13 @@ func Setup(applier CommandApplier, snapshotCreatorApplier SnapshotCreatorApplier
 	go func() {
 		for isTurnedLeader := range leaderCh {
 			if isTurnedLeader {
+				followerHealthReports.reset()
 				PublishCommand("leader-uri", thisLeaderURI)
 			}
 		}
 	}()
 
-	setupHttpClient()
+	SetupHttpClient()
 
 	atomic.StoreInt64(&raftSetupComplete, 1)
 	return nil
@@ -196,19 +292,28 @@ func normalizeRaftHostnameIP(host string) (string, error) {
 	return host, fmt.Errorf("%+v resolved but no IP found", host)
 }
 
+// splitRaftNode splits a raft node into host and port, the port being empty when the node has
+// none. IPv6 addresses with a port are expected in brackets, as in "[::1]:10008".
+func splitRaftNode(node string) (host string, port string) {
+	if host, port, err := net.SplitHostPort(node); err == nil {
+		return host, port
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(node, "["), "]"), ""
+}
+
 // normalizeRaftNode attempts to make sure there's a port to the given node.
 // It consults the DefaultRaftPort when there isn't
 func normalizeRaftNode(node string) (string, error) {
-	hostPort := strings.Split(node, ":")
-	host, err := normalizeRaftHostnameIP(hostPort[0])
+	host, port := splitRaftNode(node)
+	host, err := normalizeRaftHostnameIP(host)
 	if err != nil {
 		return host, err
 	}
-	if len(hostPort) > 1 {
-		return fmt.Sprintf("%s:%s", host, hostPort[1]), nil
-	} else if config.Config.DefaultRaftPort != 0 {
+	if port != "" {
+		return net.JoinHostPort(host, port), nil
+	} else if config.Config().DefaultRaftPort != 0 {
 		// No port specified, add one
-		return fmt.Sprintf("%s:%d", host, config.Config.DefaultRaftPort), nil
+		return net.JoinHostPort(host, fmt.Sprintf("%d", config.Config().DefaultRaftPort)), nil
 	} else {
 		return host, nil
 	}
@@ -228,20 +333,21 @@ func PublishCommand(op string, value interface{}) (response interface{}, err err
 
 // ReportToRaftLeader tells the leader this raft node is raft-healthy
 func ReportToRaftLeader(authenticationToken string) (err error) {
-	if err := healthRequestReportCache.Add(config.Config.RaftBind, true, cache.DefaultExpiration); err != nil {
+	if err := healthRequestReportCache.Add(config.Config().RaftBind, true, cache.DefaultExpiration); err != nil {
 		// Recently reported
 		return nil
 	}
-	path := fmt.Sprintf("raft-follower-health-report/%s/%s/%s", authenticationToken, config.Config.RaftBind, config.Config.RaftAdvertise)
+	path := fmt.Sprintf("raft-follower-health-report/%s/%s/%s", authenticationToken, config.Config().RaftBind, config.Config().RaftAdvertise)
 	_, err = HttpGetLeader(path)
 	return err
 }
 
+// IsPeer tells whether given raft address is this node's, as bound or as advertised
 func IsPeer(peer string) (bool, error) {
 	if !IsRaftEnabled() {
 		return false, RaftNotRunning
 	}
-	return (store.raftBind == peer), nil
+	return (store.raftBind == peer || store.raftAdvertise == peer), nil
 }
 
 func isRaftSetupComplete() bool {
@@ -269,8 +375,7 @@ func IsLeader() bool {
 	return GetState() == raft.Leader
 }
 
-
-
+// GetPeers returns the raft peers as known to this node
 func GetPeers() ([]string, error) {
 	if !IsRaftEnabled() {
 		return []string{}, RaftNotRunning
@@ -278,6 +383,64 @@ func GetPeers() ([]string, error) {
 	return store.peerStore.Peers()
 }
 
+// validatePeerAddress normalizes a raft address given for a membership change, requiring it
+// to be a resolvable host with a valid port
+func validatePeerAddress(addr string) (string, error) {
+	addr = strings.TrimSpace(addr)
+	if addr == "" {
+		return addr, fmt.Errorf("empty raft address")
+	}
+	host, _ := splitRaftNode(addr)
+	if net.ParseIP(host) == nil {
+		if _, err := net.LookupIP(host); err != nil {
+			return addr, fmt.Errorf("cannot resolve raft address %s: %+v", addr, err)
+		}
+	}
+	peer, err := normalizeRaftNode(addr)
+	if err != nil {
+		return addr, err
+	}
+	_, port, err := net.SplitHostPort(peer)
+	if err != nil {
+		return addr, fmt.Errorf("invalid raft address %s: %+v", addr, err)
+	}
+	if portNumber, err := strconv.Atoi(port); err != nil || portNumber <= 0 || portNumber > 65535 {
+		return addr, fmt.Errorf("invalid raft port in %s", addr)
+	}
+	return peer, nil
+}
+
+// AddPeer adds the node at given raft address to the cluster. It must run on the leader.
+// The new peer set is persisted by every node, and survives restarts.
+func AddPeer(addr string) (peer string, err error) {
+	if !IsRaftEnabled() {
+		return addr, RaftNotRunning
+	}
+	if peer, err = validatePeerAddress(addr); err != nil {
+		return peer, err
+	}
+	if !IsLeader() {
+		return peer, fmt.Errorf("not leader")
+	}
+	return peer, store.AddPeer(peer)
+}
+
+// RemovePeer removes the node at given raft address from the cluster. It must run on the
+// leader. The address need not resolve anymore, as is the case for a decommissioned host.
+// Removing the leader itself makes it step down once the change is committed.
+func RemovePeer(addr string) (peer string, err error) {
+	if !IsRaftEnabled() {
+		return addr, RaftNotRunning
+	}
+	if peer, err = normalizeRaftNode(strings.TrimSpace(addr)); err != nil {
+		return peer, err
+	}
+	if !IsLeader() {
+		return peer, fmt.Errorf("not leader")
+	}
+	return peer, store.RemovePeer(peer)
+}
+
 // IsPartOfQuorum returns `true` when this node is part of the raft quorum, meaning its
 // data and opinion are trustworthy.
 // Comapre that to a node which has left (or has not yet joined) the quorum: it has stale data.
@@ -295,6 +458,7 @@ func OnHealthReport(authenticationToken, raftBind, raftAdvertise string) (err er
 		return log.Errorf("Raft health report: unknown token %s", authenticationToken)
 	}
 	healthReportsCache.Set(raftAdvertise, true, cache.DefaultExpiration)
+	followerHealthReports.set(raftAdvertise)
 	return nil
 }
 
@@ -6,5 +6,6 @@ import (
 
 type SnapshotCreatorApplier interface {
 	GetData() (data []byte, err error)
-	Restore(rc io.ReadCloser) error
+	// Restore is given the raft index of the last log entry included in the snapshot
+	Restore(rc io.ReadCloser, index uint64) error
 }
@@ -0,0 +1,118 @@
+package oraft
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// followerHealthReportsTracker keeps the time each member last reported its health to this
+// node, which it does while this node is the leader. This is an application level heartbeat,
+// independent of raft replication.
+type followerHealthReportsTracker struct {
+	mutex   sync.Mutex
+	reports map[string]time.Time
+}
+
+var followerHealthReports = &followerHealthReportsTracker{reports: make(map[string]time.Time)}
+
+func (tracker *followerHealthReportsTracker) set(raftAdvertise string) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	tracker.reports[raftAdvertise] = time.Now()
+}
+
+// reset forgets reports received during an earlier leadership
+func (tracker *followerHealthReportsTracker) reset() {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	tracker.reports = make(map[string]time.Time)
+}
+
+func (tracker *followerHealthReportsTracker) get() (reports []FollowerHealthReport) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	for raftAdvertise, lastHealthReport := range tracker.reports {
+		reports = append(reports, FollowerHealthReport{
+			RaftAdvertise:                raftAdvertise,
+			LastHealthReport:             lastHealthReport,
+			SecondsSinceLastHealthReport: time.Since(lastHealthReport).Seconds(),
+		})
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].RaftAdvertise < reports[j].RaftAdvertise })
+	return reports
+}
+
+// FollowerHealthReport is the last time the leader got the periodic health report of a member.
+// It does not tell how far the member is in replicating the raft log.
+type FollowerHealthReport struct {
+	RaftAdvertise                string
+	LastHealthReport             time.Time
+	SecondsSinceLastHealthReport float64
+}
+
+// RaftStatus describes the raft state of this node
+type RaftStatus struct {
+	State             string
+	Leader            string
+	Term              uint64
+	LastLogIndex      uint64
+	LastLogTerm       uint64
+	CommitIndex       uint64
+	AppliedIndex      uint64
+	FSMPending        uint64
+	LastSnapshotIndex uint64
+	LastSnapshotTerm  uint64
+	LastContact       string // Time since this node last heard from the leader: "never", or "0" on the leader
+	Peers             []string
+	// Range of log entries kept in the relational log store, not yet compacted into a snapshot
+	StoredFirstIndex uint64
+	StoredLastIndex  uint64
+	// Followers is only populated on the leader
+	Followers []FollowerHealthReport
+}
+
+// GetRaftStatus returns the raft state of this node, as reported by raft and the log store
+func GetRaftStatus() (*RaftStatus, error) {
+	if !IsRaftEnabled() {
+		return nil, RaftNotRunning
+	}
+	if !isRaftSetupComplete() {
+		return nil, fmt.Errorf("raft setup is not complete")
+	}
+	stats := getRaft().Stats()
+	statsUint := func(name string) uint64 {
+		value, _ := strconv.ParseUint(stats[name], 10, 64)
+		return value
+	}
+	status := &RaftStatus{
+		State:             stats["state"],
+		Leader:            GetLeader(),
+		Term:              statsUint("term"),
+		LastLogIndex:      statsUint("last_log_index"),
+		LastLogTerm:       statsUint("last_log_term"),
+		CommitIndex:       statsUint("commit_index"),
+		AppliedIndex:      statsUint("applied_index"),
+		FSMPending:        statsUint("fsm_pending"),
+		LastSnapshotIndex: statsUint("last_snapshot_index"),
+		LastSnapshotTerm:  statsUint("last_snapshot_term"),
+		LastContact:       stats["last_contact"],
+		Followers:         []FollowerHealthReport{},
+	}
+	var err error
+	if status.Peers, err = GetPeers(); err != nil {
+		return status, err
+	}
+	if status.StoredFirstIndex, err = store.logStore.FirstIndex(); err != nil {
+		return status, err
+	}
+	if status.StoredLastIndex, err = store.logStore.LastIndex(); err != nil {
+		return status, err
+	}
+	if IsLeader() {
+		status.Followers = followerHealthReports.get()
+	}
+	return status, nil
+}
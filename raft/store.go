@@ -19,6 +19,7 @@ type Store struct {
 
 	raft      *raft.Raft // The consensus mechanism
 	peerStore raft.PeerStore
+	logStore  *RelationalStore
 
 	applier                CommandApplier
 	snapshotCreatorApplier SnapshotCreatorApplier
@@ -69,20 +70,6 @@ func (store *Store) Open(peerNodes []string) error {
 	}
 	log.Debugf("raft: peers=%+v", peers)
 
-	// Create peer storage.
-	peerStore := &raft.StaticPeers{}
-	if err := peerStore.SetPeers(peers); err != nil {
-		return err
-	}
-
-	// Allow the node to enter single-mode, potentially electing itself, if
-	// explicitly enabled and there is only 1 node in the cluster already.
-	if len(peerNodes) == 0 && len(peers) <= 1 {
-		log.Infof("enabling single-node mode")
-		config.EnableSingleNode = true
-		config.DisableBootstrapAfterElect = false
-	}
-
 	if _, err := os.Stat(store.raftDir); err != nil {
 		if os.IsNotExist(err) {
 			// path does not exist
@@ -96,6 +83,30 @@ func (store *Store) Open(peerNodes []string) error {
 		}
 	}
 
+	// Create peer storage. Membership changes are persisted in the data dir, and once there
+	// are any, they take precedence over the configured peers.
+	peerStore := raft.NewJSONPeers(store.raftDir, transport)
+	persistedPeers, err := peerStore.Peers()
+	if err != nil {
+		return log.Errorf("cannot read persisted raft peers: %+v", err)
+	}
+	if len(persistedPeers) > 0 {
+		if !samePeers(persistedPeers, peers) {
+			log.Infof("raft: using persisted peers %+v rather than configured RaftNodes %+v", persistedPeers, peers)
+		}
+		peers = persistedPeers
+	} else if err := peerStore.SetPeers(peers); err != nil {
+		return err
+	}
+
+	// Allow the node to enter single-mode, potentially electing itself, if
+	// explicitly enabled and there is only 1 node in the cluster already.
+	if len(peerNodes) == 0 && len(peers) <= 1 {
+		log.Infof("enabling single-node mode")
+		config.EnableSingleNode = true
+		config.DisableBootstrapAfterElect = false
+	}
+
 	// Create the snapshot store. This allows the Raft to truncate the log.
 	snapshots, err := NewFileSnapshotStore(store.raftDir, retainSnapshotCount, os.Stderr)
 	if err != nil {
@@ -111,6 +122,7 @@ func (store *Store) Open(peerNodes []string) error {
 		return fmt.Errorf("error creating new raft: %s", err)
 	}
 	store.peerStore = peerStore
+	store.logStore = logStore
 	log.Infof("new raft created")
 
 	return nil
@@ -141,6 +153,19 @@ func (store *Store) RemovePeer(addr string) error {
 	return nil
 }
 
+// samePeers tells whether two peer lists hold the same peers, regardless of order
+func samePeers(peers []string, otherPeers []string) bool {
+	if len(peers) != len(otherPeers) {
+		return false
+	}
+	for _, peer := range peers {
+		if !raft.PeerContained(otherPeers, peer) {
+			return false
+		}
+	}
+	return true
+}
+
 // genericCommand requests consensus for applying a single command.
 // This is an internal orchestrator implementation
 func (store *Store) genericCommand(op string, bytes []byte) (response interface{}, err error) {
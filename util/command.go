@@ -0,0 +1,388 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/openark/golib/log"
+)
+
+// DefaultMaxOutputBytes is the per-stream capture limit of commands which do not specify one
+const DefaultMaxOutputBytes = 1024 * 1024
+
+// killGracePeriod is the time a timed out or canceled command is given to
+// exit after SIGTERM, before its process group is sent SIGKILL
+var killGracePeriod = 10 * time.Second
+
+var ErrCommandTimedOut = errors.New("command timed out")
+var ErrCommandCanceled = errors.New("command canceled")
+
+// CommandError is returned when a command ran but did not complete successfully.
+// It retains the underlying error so that the exit status can be recovered.
+type CommandError struct {
+	Err    error
+	Output string
+	Limit  string // The resource limit the command was killed for exceeding, if any
+}
+
+func (this *CommandError) Error() string {
+	if this.Limit != "" {
+		return fmt.Sprintf("(%s; %s limit exceeded) %s", this.Err.Error(), this.Limit, this.Output)
+	}
+	return fmt.Sprintf("(%s) %s", this.Err.Error(), this.Output)
+}
+
+// ExitStatus returns the exit status of a command given the error it returned:
+// 0 for no error, the process exit status if known, and -1 otherwise.
+func ExitStatus(err error) int {
+	if err == nil {
+		return 0
+	}
+	if commandError, ok := err.(*CommandError); ok {
+		err = commandError.Err
+	}
+	if exitError, ok := err.(*exec.ExitError); ok {
+		if waitStatus, ok := exitError.Sys().(syscall.WaitStatus); ok {
+			return waitStatus.ExitStatus()
+		}
+	}
+	return -1
+}
+
+// IsCommandTimedOut returns true when the given error indicates a command was killed
+// for exceeding its deadline
+func IsCommandTimedOut(err error) bool {
+	if commandError, ok := err.(*CommandError); ok {
+		err = commandError.Err
+	}
+	return err == ErrCommandTimedOut
+}
+
+// CommandContext returns a context which expires after given timeout. A zero timeout
+// means no deadline, in which case the context can still be canceled.
+func CommandContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+// CommandSpec describes a script to run along with the positional arguments and
+// environment it is given
+type CommandSpec struct {
+	Text           string
+	Arguments      []string
+	Interpreter    string              // Program the script is handed to as a file. Defaults to bash
+	Argv           []string            // When set, this command line is executed directly, without a script or interpreter
+	Dir            string              // Working directory. Defaults to ours
+	Env            []string            // "key=value" entries added to the inherited environment
+	Secrets        []string            `json:"-"` // Values masked wherever the command, its output or errors are logged or returned
+	EnvAllowList   []string            // When not nil, only these variables of our environment are inherited; "PREFIX_*" matches by prefix
+	Credential     *syscall.Credential `json:"-"` // When set, the user and group the command runs as
+	Limits         *ResourceLimits     // Resources the command may use. nil for no limits
+	CaptureOutput  bool                // When false, output is discarded. Scripts which leave background children must not capture output
+	MaxOutputBytes int                 // Per stream capture limit. 0 for DefaultMaxOutputBytes
+	// OnOutputLine, when set, is given each line of captured output as it is produced. Stream is
+	// "stdout" or "stderr". It is called from concurrent goroutines.
+	OnOutputLine func(stream string, line string) `json:"-"`
+}
+
+// environ returns the full environment for the command, or nil to inherit ours as is
+func (this *CommandSpec) environ() []string {
+	if len(this.Env) == 0 && this.EnvAllowList == nil {
+		return nil
+	}
+	environ := os.Environ()
+	if this.EnvAllowList != nil {
+		environ = []string{}
+		for _, variable := range os.Environ() {
+			if this.isAllowedVariable(strings.SplitN(variable, "=", 2)[0]) {
+				environ = append(environ, variable)
+			}
+		}
+	}
+	return append(environ, this.Env...)
+}
+
+func (this *CommandSpec) isAllowedVariable(name string) bool {
+	for _, allowed := range this.EnvAllowList {
+		if allowed == name || (strings.HasSuffix(allowed, "*") && strings.HasPrefix(name, strings.TrimSuffix(allowed, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// newCmd returns the command to run: the script written to a temporary file and handed to
+// the interpreter, or Argv as is. The returned file, if any, must be removed once done.
+func (this *CommandSpec) newCmd() (cmd *exec.Cmd, scriptFile string, err error) {
+	if len(this.Argv) > 0 {
+		cmd = exec.Command(this.Argv[0], this.Argv[1:]...)
+	} else {
+		interpreter := this.Interpreter
+		if interpreter == "" {
+			interpreter = "bash"
+		}
+		if cmd, scriptFile, err = generateScript(interpreter, this.Text, this.Arguments...); err != nil {
+			return nil, scriptFile, err
+		}
+	}
+	cmd.Env = this.environ()
+	cmd.Dir = this.Dir
+	if this.Credential != nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{Credential: this.Credential}
+		if scriptFile != "" {
+			// The script must remain readable once privileges are dropped
+			if err := os.Chown(scriptFile, int(this.Credential.Uid), int(this.Credential.Gid)); err != nil {
+				return nil, scriptFile, fmt.Errorf("cannot hand script over to uid %d: %+v", this.Credential.Uid, err)
+			}
+		}
+	}
+	return cmd, scriptFile, nil
+}
+
+// CommandResult is the outcome of running a command
+type CommandResult struct {
+	Stdout          string
+	Stderr          string
+	StdoutJSON      interface{} `json:",omitempty"` // Decoded stdout, for processes with outputFormat "json"
+	ExitCode        int         // -1 when the command did not exit normally
+	Signal          string      // Name of the signal which terminated the command, if any
+	TimedOut        bool
+	StartTime       time.Time
+	EndTime         time.Time
+	DurationSeconds float64
+	StdoutTruncated bool
+	StderrTruncated bool
+	// LimitExceeded is the limit the command ran into: memory, cpu or processes when it was
+	// killed for exceeding it, else output when captured output was truncated. Exceeding the
+	// open files limit, or the address space limit which stands for memory without a cgroup,
+	// makes system calls fail rather than kill the command; the command may handle that, so
+	// it is not detected and not reported here.
+	LimitExceeded string `json:",omitempty"`
+}
+
+// cappedBuffer retains up to limit bytes written to it, silently discarding the rest
+type cappedBuffer struct {
+	buffer    bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func newCappedBuffer(limit int) *cappedBuffer {
+	return &cappedBuffer{limit: limit}
+}
+
+func (this *cappedBuffer) Write(p []byte) (int, error) {
+	if room := this.limit - this.buffer.Len(); room < len(p) {
+		this.truncated = true
+		if room > 0 {
+			this.buffer.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return this.buffer.Write(p)
+}
+
+func (this *cappedBuffer) String() string {
+	return this.buffer.String()
+}
+
+// lineWriter passes each line written to it, without its trailing newline, to a callback.
+// Overly long lines are passed on in pieces of maxOutputLineBytes.
+type lineWriter struct {
+	stream  string
+	onLine  func(stream string, line string)
+	partial []byte
+}
+
+const maxOutputLineBytes = 64 * 1024
+
+func (this *lineWriter) Write(p []byte) (int, error) {
+	this.partial = append(this.partial, p...)
+	for {
+		i := bytes.IndexByte(this.partial, '\n')
+		if i < 0 && len(this.partial) >= maxOutputLineBytes {
+			i = maxOutputLineBytes
+			this.onLine(this.stream, string(this.partial[:i]))
+			this.partial = this.partial[i:]
+			continue
+		}
+		if i < 0 {
+			return len(p), nil
+		}
+		this.onLine(this.stream, string(this.partial[:i]))
+		this.partial = this.partial[i+1:]
+	}
+}
+
+// flush passes on a last line lacking its newline
+func (this *lineWriter) flush() {
+	if len(this.partial) > 0 {
+		this.onLine(this.stream, string(this.partial))
+		this.partial = nil
+	}
+}
+
+// RunCommand runs the given script with bash, bound to a context: when the context
+// expires or is canceled the command's entire process tree is terminated.
+// A result is returned whenever the command was started, even if it then failed.
+func RunCommand(ctx context.Context, spec *CommandSpec) (*CommandResult, error) {
+	redact := func(text string) string {
+		return Redact(text, spec.Secrets...)
+	}
+	// show the actual command we have been asked to run
+	log.Infof("CommandRun(%v,%+v)", redact(spec.Text), redact(strings.Join(spec.Arguments, " ")))
+
+	cmd, scriptFile, err := spec.newCmd()
+	if scriptFile != "" {
+		defer os.Remove(scriptFile)
+	}
+	if err != nil {
+		return nil, log.Errore(err)
+	}
+	var onStarted func()
+	var cgroup *jobCgroup
+	if spec.Limits.IsSet() {
+		if cgroup = newJobCgroup(spec.Limits); cgroup != nil {
+			defer cgroup.remove()
+		}
+		cmd = limitedCmd(cmd, spec.Limits, cgroup)
+	}
+	if cgroup != nil {
+		// The command waits for the write end to be written to or closed, see limitedCmd
+		reader, writer, err := os.Pipe()
+		if err != nil {
+			return nil, log.Errore(err)
+		}
+		defer reader.Close()
+		defer writer.Close()
+		cmd.ExtraFiles = []*os.File{reader}
+		onStarted = func() {
+			reader.Close()
+			if err := cgroup.add(cmd.Process.Pid); err != nil {
+				log.Warningf("CommandRun: cannot move process %d into cgroup %s; cgroup limits do not apply: %+v", cmd.Process.Pid, cgroup.path, err)
+			}
+			writer.Close()
+		}
+	}
+
+	maxOutputBytes := spec.MaxOutputBytes
+	if maxOutputBytes <= 0 {
+		maxOutputBytes = DefaultMaxOutputBytes
+	}
+	stdout := newCappedBuffer(maxOutputBytes)
+	stderr := newCappedBuffer(maxOutputBytes)
+	if spec.CaptureOutput {
+		cmd.Stdout = stdout
+		cmd.Stderr = stderr
+	}
+	var lineWriters []*lineWriter
+	if spec.CaptureOutput && spec.OnOutputLine != nil {
+		onLine := func(stream string, line string) {
+			spec.OnOutputLine(stream, redact(line))
+		}
+		stdoutLines := &lineWriter{stream: "stdout", onLine: onLine}
+		stderrLines := &lineWriter{stream: "stderr", onLine: onLine}
+		lineWriters = append(lineWriters, stdoutLines, stderrLines)
+		cmd.Stdout = io.MultiWriter(stdout, stdoutLines)
+		cmd.Stderr = io.MultiWriter(stderr, stderrLines)
+	}
+
+	result := &CommandResult{ExitCode: -1, StartTime: time.Now()}
+	log.Infof("CommandRun/running: %s", redact(strings.Join(cmd.Args, " ")))
+	err = runCommandContext(ctx, cmd, onStarted)
+	for _, lines := range lineWriters {
+		lines.flush()
+	}
+	result.EndTime = time.Now()
+	result.DurationSeconds = result.EndTime.Sub(result.StartTime).Seconds()
+	result.Stdout, result.StdoutTruncated = redact(stdout.String()), stdout.truncated
+	result.Stderr, result.StderrTruncated = redact(stderr.String()), stderr.truncated
+	result.TimedOut = (err == ErrCommandTimedOut)
+	if cmd.ProcessState != nil {
+		if waitStatus, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); ok {
+			result.ExitCode = waitStatus.ExitStatus()
+			if waitStatus.Signaled() {
+				result.Signal = waitStatus.Signal().String()
+			}
+		}
+	}
+	if spec.CaptureOutput {
+		log.Infof("CommandRun: stdout: %s", result.Stdout)
+		log.Infof("CommandRun: stderr: %s", result.Stderr)
+	}
+
+	if err != nil && spec.Limits.IsSet() {
+		if cgroup != nil {
+			result.LimitExceeded = cgroup.limitExceeded()
+		}
+		if result.LimitExceeded == "" && cpuLimitExceeded(spec.Limits, cmd.ProcessState) {
+			result.LimitExceeded = LimitCPUTime
+		}
+	}
+	if result.LimitExceeded == "" && (result.StdoutTruncated || result.StderrTruncated) {
+		result.LimitExceeded = LimitOutput
+	}
+
+	if err != nil {
+		log.Errorf("CommandRun: failed. exit status %d", result.ExitCode)
+		commandError := &CommandError{Err: err, Output: strings.TrimSpace(result.Stderr)}
+		if result.LimitExceeded != LimitOutput {
+			// Truncated output does not make a command fail
+			commandError.Limit = result.LimitExceeded
+		}
+		return result, log.Errore(commandError)
+	}
+	log.Infof("CommandRun successful. exit status %d", result.ExitCode)
+	return result, nil
+}
+
+// runCommandContext starts the command in a process group of its own and waits for it.
+// Should the context be done first, the group is sent SIGTERM, followed by SIGKILL
+// if it has not exited within killGracePeriod. onStarted, if not nil, is called once
+// the command started.
+func runCommandContext(ctx context.Context, cmd *exec.Cmd, onStarted func()) error {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	if onStarted != nil {
+		onStarted()
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+	}
+
+	// The group id equals the pid of its leader
+	pgid := cmd.Process.Pid
+	log.Warningf("runCommandContext: %s; terminating process group %d", ctx.Err(), pgid)
+	syscall.Kill(-pgid, syscall.SIGTERM)
+	select {
+	case <-done:
+	case <-time.After(killGracePeriod):
+		log.Warningf("runCommandContext: process group %d still running after %+v; killing", pgid, killGracePeriod)
+		syscall.Kill(-pgid, syscall.SIGKILL)
+		<-done
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return ErrCommandTimedOut
+	}
+	return ErrCommandCanceled
+}
@@ -0,0 +1,53 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestRunCommandTimeoutKillsProcessTree runs a script which leaves a background child behind:
+// once the script times out, the child must be gone too, and never get to leave its marker.
+func TestRunCommandTimeoutKillsProcessTree(t *testing.T) {
+	dir, err := ioutil.TempDir("", "command-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	marker := filepath.Join(dir, "marker")
+
+	ctx, cancel := CommandContext(100 * time.Millisecond)
+	defer cancel()
+	result, err := RunCommand(ctx, &CommandSpec{Text: `(sleep 0.5; touch "$1") & sleep 5`, Arguments: []string{marker}})
+	if !IsCommandTimedOut(err) {
+		t.Fatalf("expected the command to time out, got %+v", err)
+	}
+	if !result.TimedOut || result.ExitCode != -1 || result.Signal != "terminated" {
+		t.Errorf("expected a timed out result terminated by SIGTERM, got %+v", result)
+	}
+	time.Sleep(time.Second)
+	if _, err := os.Stat(marker); err == nil {
+		t.Errorf("expected the background child to be killed along with the script")
+	}
+}
+
+func TestRunCommandCancelEscalatesToKill(t *testing.T) {
+	defer func(gracePeriod time.Duration) { killGracePeriod = gracePeriod }(killGracePeriod)
+	killGracePeriod = 100 * time.Millisecond
+
+	ctx, cancel := CommandContext(0)
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start := time.Now()
+	result, err := RunCommand(ctx, &CommandSpec{Text: `trap '' TERM; sleep 5`})
+	if err == nil || IsCommandTimedOut(err) {
+		t.Fatalf("expected the command to be canceled, got %+v", err)
+	}
+	if result.TimedOut || result.Signal != "killed" {
+		t.Errorf("expected a canceled result killed by SIGKILL, got %+v", result)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected the command killed after the grace period, took %+v", elapsed)
+	}
+}
@@ -0,0 +1,223 @@
+package util
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSearchLimit bounds the search for the next activation of a schedule which may never fire (e.g. Feb 30th)
+const cronSearchLimit = 5 * 366 * 24 * time.Hour
+
+type cronField struct {
+	name     string
+	min      uint
+	max      uint
+	cycleMax uint // Highest value of the cycle wrap-around ranges follow, when below max
+	names    map[string]uint
+}
+
+var (
+	cronSecondField = cronField{name: "second", min: 0, max: 59}
+	cronMinuteField = cronField{name: "minute", min: 0, max: 59}
+	cronHourField   = cronField{name: "hour", min: 0, max: 23}
+	cronDomField    = cronField{name: "day of month", min: 1, max: 31}
+	cronMonthField  = cronField{name: "month", min: 1, max: 12, names: map[string]uint{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// day of week accepts 7 as an alias of Sunday
+	cronDowField = cronField{name: "day of week", min: 0, max: 7, cycleMax: 6, names: map[string]uint{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 0 1 1 *",
+	"@annually": "0 0 0 1 1 *",
+	"@monthly":  "0 0 0 1 * *",
+	"@weekly":   "0 0 0 * * 0",
+	"@daily":    "0 0 0 * * *",
+	"@midnight": "0 0 0 * * *",
+	"@hourly":   "0 0 * * * *",
+}
+
+// CronSchedule is a parsed cron expression. Each field is a bitmask of matching values.
+type CronSchedule struct {
+	Expression string
+	Location   *time.Location
+
+	second, minute, hour, dom, month, dow uint64
+	domRestricted, dowRestricted          bool
+}
+
+// ParseCronSchedule parses a standard 5 field (minute hour day-of-month month day-of-week)
+// or 6 field (with leading seconds) cron expression, or one of the @hourly style descriptors.
+// Activation times are computed in given location; nil means local time.
+func ParseCronSchedule(expression string, location *time.Location) (*CronSchedule, error) {
+	if location == nil {
+		location = time.Local
+	}
+	spec := strings.TrimSpace(expression)
+	if descriptor, ok := cronDescriptors[strings.ToLower(spec)]; ok {
+		spec = descriptor
+	}
+	fields := strings.Fields(spec)
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	default:
+		return nil, fmt.Errorf("cron expression %q: expected 5 or 6 fields, found %d", expression, len(fields))
+	}
+
+	schedule := &CronSchedule{Expression: expression, Location: location}
+	var err error
+	if schedule.second, err = parseCronField(fields[0], cronSecondField); err != nil {
+		return nil, fmt.Errorf("cron expression %q: %+v", expression, err)
+	}
+	if schedule.minute, err = parseCronField(fields[1], cronMinuteField); err != nil {
+		return nil, fmt.Errorf("cron expression %q: %+v", expression, err)
+	}
+	if schedule.hour, err = parseCronField(fields[2], cronHourField); err != nil {
+		return nil, fmt.Errorf("cron expression %q: %+v", expression, err)
+	}
+	if schedule.dom, err = parseCronField(fields[3], cronDomField); err != nil {
+		return nil, fmt.Errorf("cron expression %q: %+v", expression, err)
+	}
+	if schedule.month, err = parseCronField(fields[4], cronMonthField); err != nil {
+		return nil, fmt.Errorf("cron expression %q: %+v", expression, err)
+	}
+	if schedule.dow, err = parseCronField(fields[5], cronDowField); err != nil {
+		return nil, fmt.Errorf("cron expression %q: %+v", expression, err)
+	}
+	if schedule.dow&(1<<7) != 0 {
+		schedule.dow |= 1 << 0
+	}
+	schedule.domRestricted = !isCronWildcard(fields[3])
+	schedule.dowRestricted = !isCronWildcard(fields[5])
+	return schedule, nil
+}
+
+func isCronWildcard(field string) bool {
+	return field == "*" || field == "?"
+}
+
+// parseCronField parses a comma separated list of values, ranges and steps into a bitmask
+func parseCronField(field string, spec cronField) (bits uint64, err error) {
+	for _, term := range strings.Split(field, ",") {
+		rangeTerm := term
+		step := uint(1)
+		if tokens := strings.SplitN(term, "/", 2); len(tokens) == 2 {
+			rangeTerm = tokens[0]
+			parsedStep, err := strconv.ParseUint(tokens[1], 10, 32)
+			if err != nil || parsedStep == 0 {
+				return 0, fmt.Errorf("invalid step in %s field: %q", spec.name, term)
+			}
+			step = uint(parsedStep)
+		}
+		low, high := spec.min, spec.max
+		switch {
+		case isCronWildcard(rangeTerm):
+		case strings.Contains(rangeTerm, "-"):
+			tokens := strings.SplitN(rangeTerm, "-", 2)
+			if low, err = parseCronValue(tokens[0], spec); err != nil {
+				return 0, err
+			}
+			if high, err = parseCronValue(tokens[1], spec); err != nil {
+				return 0, err
+			}
+			if low > high {
+				// Wrap-around range, such as sat-mon or 22-2
+				bits |= cronWrappedRangeBits(low, high, step, spec)
+				continue
+			}
+		default:
+			if low, err = parseCronValue(rangeTerm, spec); err != nil {
+				return 0, err
+			}
+			if step == 1 {
+				high = low
+			}
+		}
+		for value := low; value <= high; value += step {
+			bits |= 1 << value
+		}
+	}
+	return bits, nil
+}
+
+// cronWrappedRangeBits returns the bitmask of a range running from low up to the end of the
+// field's cycle, then on from its start up to high
+func cronWrappedRangeBits(low uint, high uint, step uint, spec cronField) (bits uint64) {
+	cycleMax := spec.max
+	if spec.cycleMax != 0 {
+		cycleMax = spec.cycleMax
+	}
+	size := cycleMax - spec.min + 1
+	if low > cycleMax {
+		low -= size
+	}
+	if high > cycleMax {
+		high -= size
+	}
+	span := (high + size - low) % size
+	for offset := uint(0); offset <= span; offset += step {
+		bits |= 1 << (spec.min + (low-spec.min+offset)%size)
+	}
+	return bits
+}
+
+func parseCronValue(token string, spec cronField) (uint, error) {
+	if value, ok := spec.names[strings.ToLower(token)]; ok {
+		return value, nil
+	}
+	value, err := strconv.ParseUint(token, 10, 32)
+	if err != nil || uint(value) < spec.min || uint(value) > spec.max {
+		return 0, fmt.Errorf("invalid value in %s field: %q; expected %d-%d", spec.name, token, spec.min, spec.max)
+	}
+	return uint(value), nil
+}
+
+// dayMatches applies the cron rule whereby, when both day of month and day of week are
+// restricted, a day matching either one is a match
+func (this *CronSchedule) dayMatches(t time.Time) bool {
+	domMatch := this.dom&(1<<uint(t.Day())) != 0
+	dowMatch := this.dow&(1<<uint(t.Weekday())) != 0
+	if this.domRestricted && this.dowRestricted {
+		return domMatch || dowMatch
+	}
+	return domMatch && dowMatch
+}
+
+// Next returns the first activation time strictly after given time, or the zero time if
+// the schedule never fires.
+func (this *CronSchedule) Next(after time.Time) time.Time {
+	t := after.In(this.Location).Truncate(time.Second).Add(time.Second)
+	limit := t.Add(cronSearchLimit)
+	for t.Before(limit) {
+		if this.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, this.Location)
+			continue
+		}
+		if !this.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, this.Location)
+			continue
+		}
+		if this.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, this.Location)
+			continue
+		}
+		if this.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Truncate(time.Minute).Add(time.Minute)
+			continue
+		}
+		if this.second&(1<<uint(t.Second())) == 0 {
+			t = t.Add(time.Second)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
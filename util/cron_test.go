@@ -0,0 +1,122 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func cronBits(values ...uint) (bits uint64) {
+	for _, value := range values {
+		bits |= 1 << value
+	}
+	return bits
+}
+
+func TestParseCronField(t *testing.T) {
+	tests := []struct {
+		field   string
+		spec    cronField
+		bits    uint64
+		invalid bool
+	}{
+		{field: "*", spec: cronHourField, bits: cronBits(0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23)},
+		{field: "?", spec: cronDomField, bits: cronBits(1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31)},
+		{field: "5", spec: cronMinuteField, bits: cronBits(5)},
+		{field: "1,15,30", spec: cronMinuteField, bits: cronBits(1, 15, 30)},
+		{field: "10-13", spec: cronHourField, bits: cronBits(10, 11, 12, 13)},
+		{field: "*/15", spec: cronMinuteField, bits: cronBits(0, 15, 30, 45)},
+		{field: "10-20/5", spec: cronMinuteField, bits: cronBits(10, 15, 20)},
+		{field: "50/4", spec: cronSecondField, bits: cronBits(50, 54, 58)},
+		{field: "jan,JUL", spec: cronMonthField, bits: cronBits(1, 7)},
+		{field: "mon-fri", spec: cronDowField, bits: cronBits(1, 2, 3, 4, 5)},
+		{field: "5-7", spec: cronDowField, bits: cronBits(5, 6, 7)},
+		{field: "mon-sun", spec: cronDowField, bits: cronBits(0, 1, 2, 3, 4, 5, 6)},
+		{field: "sat-mon", spec: cronDowField, bits: cronBits(6, 0, 1)},
+		{field: "fri-tue/2", spec: cronDowField, bits: cronBits(5, 0, 2)},
+		{field: "7-2", spec: cronDowField, bits: cronBits(0, 1, 2)},
+		{field: "22-2", spec: cronHourField, bits: cronBits(22, 23, 0, 1, 2)},
+		{field: "nov-feb", spec: cronMonthField, bits: cronBits(11, 12, 1, 2)},
+		{field: "30-2", spec: cronDomField, bits: cronBits(30, 31, 1, 2)},
+		{field: "60", spec: cronMinuteField, invalid: true},
+		{field: "0", spec: cronDomField, invalid: true},
+		{field: "8", spec: cronDowField, invalid: true},
+		{field: "*/0", spec: cronMinuteField, invalid: true},
+		{field: "1-x", spec: cronMinuteField, invalid: true},
+		{field: "abc", spec: cronMonthField, invalid: true},
+		{field: "", spec: cronMinuteField, invalid: true},
+	}
+	for _, test := range tests {
+		bits, err := parseCronField(test.field, test.spec)
+		if test.invalid {
+			if err == nil {
+				t.Errorf("%s field %q: expected an error, got %b", test.spec.name, test.field, bits)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s field %q: unexpected error: %+v", test.spec.name, test.field, err)
+			continue
+		}
+		if bits != test.bits {
+			t.Errorf("%s field %q: expected %b, got %b", test.spec.name, test.field, test.bits, bits)
+		}
+	}
+}
+
+func TestParseCronScheduleErrors(t *testing.T) {
+	for _, expression := range []string{"", "* * * *", "* * * * * * *", "61 * * * *", "* * * * mon-xyz", "@fortnightly"} {
+		if _, err := ParseCronSchedule(expression, time.UTC); err == nil {
+			t.Errorf("cron expression %q: expected an error", expression)
+		}
+	}
+}
+
+func TestCronScheduleNext(t *testing.T) {
+	// A Wednesday
+	after := time.Date(2026, time.January, 14, 10, 30, 0, 0, time.UTC)
+	tests := []struct {
+		expression string
+		next       time.Time
+	}{
+		{"* * * * *", time.Date(2026, time.January, 14, 10, 31, 0, 0, time.UTC)},
+		{"*/5 * * * * *", time.Date(2026, time.January, 14, 10, 30, 5, 0, time.UTC)},
+		{"30 10 * * *", time.Date(2026, time.January, 15, 10, 30, 0, 0, time.UTC)},
+		{"0 12 * * *", time.Date(2026, time.January, 14, 12, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2026, time.January, 14, 11, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2026, time.January, 15, 0, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2026, time.January, 18, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2026, time.February, 1, 0, 0, 0, 0, time.UTC)},
+		{"@yearly", time.Date(2027, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{"30 2 * * mon-fri", time.Date(2026, time.January, 15, 2, 30, 0, 0, time.UTC)},
+		{"0 9 * * sat-mon", time.Date(2026, time.January, 17, 9, 0, 0, 0, time.UTC)},
+		{"0 9 * * 7", time.Date(2026, time.January, 18, 9, 0, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2026, time.February, 1, 0, 0, 0, 0, time.UTC)},
+		// Day of month or day of week, when both are restricted
+		{"0 0 20 * fri", time.Date(2026, time.January, 16, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 feb *", time.Date(2028, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 feb *", time.Time{}},
+	}
+	for _, test := range tests {
+		schedule, err := ParseCronSchedule(test.expression, time.UTC)
+		if err != nil {
+			t.Errorf("cron expression %q: unexpected error: %+v", test.expression, err)
+			continue
+		}
+		if next := schedule.Next(after); !next.Equal(test.next) {
+			t.Errorf("cron expression %q: expected next activation at %s, got %s", test.expression, test.next, next)
+		}
+	}
+}
+
+func TestCronScheduleNextInLocation(t *testing.T) {
+	location := time.FixedZone("UTC+8", 8*60*60)
+	schedule, err := ParseCronSchedule("30 2 * * *", location)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	after := time.Date(2026, time.January, 14, 0, 0, 0, 0, time.UTC)
+	expected := time.Date(2026, time.January, 14, 18, 30, 0, 0, time.UTC)
+	if next := schedule.Next(after); !next.Equal(expected) {
+		t.Errorf("expected next activation at %s, got %s", expected, next)
+	}
+}
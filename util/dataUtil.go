@@ -14,7 +14,7 @@ import (
 func StringMapAdd(target map[string]string, source map[string]string) {
 	for k, v := range source {
 		if _, ok := target[k]; ok {
-			log.Errorf("key conflict:", k)
+			log.Errorf("key conflict: %s", k)
 		}
 		target[k] = v
 	}
@@ -23,7 +23,7 @@ func StringMapAdd(target map[string]string, source map[string]string) {
 func IntMapAdd(target map[string]string, source map[string]int64) {
 	for k, v := range source {
 		if _, ok := target[k]; ok {
-			log.Errorf("key conflict:", k)
+			log.Errorf("key conflict: %s", k)
 		}
 		target[k] = strconv.FormatInt(v, 10)
 	}
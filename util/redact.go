@@ -0,0 +1,44 @@
+package util
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// RedactedValue replaces secrets in logs, errors, stored results and API responses
+const RedactedValue = "********"
+
+var redactionPatterns = []*regexp.Regexp{}
+var redactionPatternsMutex sync.RWMutex
+
+// SetRedactionPatterns sets the patterns masked in all command logs and results, on top of
+// the secrets of each command
+func SetRedactionPatterns(patterns []*regexp.Regexp) {
+	redactionPatternsMutex.Lock()
+	defer redactionPatternsMutex.Unlock()
+	redactionPatterns = patterns
+}
+
+// Redact masks given secret values, along with any text matching the redaction patterns
+func Redact(text string, secrets ...string) string {
+	if len(secrets) > 0 {
+		// Longest first, so that a secret containing another is masked entirely
+		sorted := append([]string{}, secrets...)
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return len(sorted[i]) > len(sorted[j])
+		})
+		for _, secret := range sorted {
+			if secret != "" {
+				text = strings.Replace(text, secret, RedactedValue, -1)
+			}
+		}
+	}
+	redactionPatternsMutex.RLock()
+	defer redactionPatternsMutex.RUnlock()
+	for _, pattern := range redactionPatterns {
+		text = pattern.ReplaceAllString(text, RedactedValue)
+	}
+	return text
+}
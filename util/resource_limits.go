@@ -0,0 +1,198 @@
+package util
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/openark/golib/log"
+)
+
+const (
+	LimitMemory    = "memory"
+	LimitCPUTime   = "cpu"
+	LimitProcesses = "processes"
+	LimitOutput    = "output"
+)
+
+// cgroupRoot is where the cgroup v2 hierarchy is mounted
+const cgroupRoot = "/sys/fs/cgroup"
+
+// cgroupRemoveInterval paces attempts to remove job cgroups still holding background processes
+const cgroupRemoveInterval = time.Minute
+
+// ResourceLimits bounds the resources a command may use. Zero values mean no limit.
+// Limits apply through setrlimit in the command's process, and through a child cgroup of
+// CgroupParent when it is set and cgroup v2 is writable.
+type ResourceLimits struct {
+	MemoryBytes uint64 // cgroup memory.max; without a cgroup, the address space rlimit
+	CPUSeconds  uint64 // CPU time rlimit
+	OpenFiles   uint64 // Open files rlimit
+	Processes   uint64 // cgroup pids.max; see limitedCmd for when the cgroup cannot be created
+	// CgroupParent is the cgroup v2 directory under which a child cgroup is created per
+	// command. Empty leaves rlimits only. It must have, or be able to enable, the memory and
+	// pids controllers in its subtree.
+	CgroupParent string
+}
+
+// IsSet returns true when any limit is set
+func (this *ResourceLimits) IsSet() bool {
+	return this != nil && (this.MemoryBytes > 0 || this.CPUSeconds > 0 || this.OpenFiles > 0 || this.Processes > 0)
+}
+
+// jobCgroup is the child cgroup a limited command runs in
+type jobCgroup struct {
+	path string
+}
+
+// newJobCgroup creates a child cgroup of CgroupParent enforcing given limits. It returns nil
+// when cgroups are not in use or cannot be written, in which case rlimits alone apply.
+func newJobCgroup(limits *ResourceLimits) *jobCgroup {
+	if limits.CgroupParent == "" || (limits.MemoryBytes == 0 && limits.Processes == 0) {
+		return nil
+	}
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err != nil {
+		log.Warningf("cgroup v2 is not mounted on %s; applying rlimits only", cgroupRoot)
+		return nil
+	}
+	if err := os.MkdirAll(limits.CgroupParent, 0755); err != nil {
+		log.Warningf("cannot create cgroup %s; applying rlimits only: %+v", limits.CgroupParent, err)
+		return nil
+	}
+	// Best effort: the controllers may already be enabled, or be delegated by a privileged manager
+	ioutil.WriteFile(filepath.Join(limits.CgroupParent, "cgroup.subtree_control"), []byte("+memory +pids"), 0644)
+
+	cgroup := &jobCgroup{path: filepath.Join(limits.CgroupParent, fmt.Sprintf("job-%s", NewToken().Hash[:16]))}
+	if err := os.Mkdir(cgroup.path, 0755); err != nil {
+		log.Warningf("cannot create cgroup %s; applying rlimits only: %+v", cgroup.path, err)
+		return nil
+	}
+	settings := map[string]uint64{"memory.max": limits.MemoryBytes, "pids.max": limits.Processes}
+	for file, value := range settings {
+		if value == 0 {
+			continue
+		}
+		if err := ioutil.WriteFile(filepath.Join(cgroup.path, file), []byte(strconv.FormatUint(value, 10)), 0644); err != nil {
+			log.Warningf("cannot set %s of cgroup %s; applying rlimits only: %+v", file, cgroup.path, err)
+			cgroup.remove()
+			return nil
+		}
+	}
+	if limits.MemoryBytes > 0 {
+		// Best effort, as swap accounting may be disabled: swapping out would let the command exceed its memory limit
+		ioutil.WriteFile(filepath.Join(cgroup.path, "memory.swap.max"), []byte("0"), 0644)
+	}
+	return cgroup
+}
+
+// add moves given process into the cgroup
+func (this *jobCgroup) add(pid int) error {
+	return ioutil.WriteFile(filepath.Join(this.path, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0644)
+}
+
+// eventCount reads a counter from one of the cgroup's events files
+func (this *jobCgroup) eventCount(file string, event string) uint64 {
+	f, err := os.Open(filepath.Join(this.path, file))
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == event {
+			count, _ := strconv.ParseUint(fields[1], 10, 64)
+			return count
+		}
+	}
+	return 0
+}
+
+// limitExceeded returns the limit the cgroup enforced, if any
+func (this *jobCgroup) limitExceeded() string {
+	if this.eventCount("memory.events", "oom_kill") > 0 {
+		return LimitMemory
+	}
+	if this.eventCount("pids.events", "max") > 0 {
+		return LimitProcesses
+	}
+	return ""
+}
+
+// remove deletes the cgroup. While background processes the command left behind still
+// live in it, removal is retried in the background.
+func (this *jobCgroup) remove() {
+	if err := os.Remove(this.path); err == nil || os.IsNotExist(err) {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(cgroupRemoveInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := os.Remove(this.path); err == nil || os.IsNotExist(err) {
+				return
+			}
+		}
+	}()
+}
+
+// limitedCmd wraps a command so that it runs under given limits. The wrapper applies rlimits
+// with bash's ulimit, then, when a cgroup is used, waits on file descriptor 3 until it has
+// been moved into the cgroup, before executing the command in its place.
+// Without a cgroup, the processes limit falls back to ulimit -u. That rlimit is per user:
+// it counts every process of the user the command runs as, not only the command's. Config
+// validation requires CgroupParent for it, so this happens only when the cgroup could not be
+// created, which is logged.
+func limitedCmd(cmd *exec.Cmd, limits *ResourceLimits, cgroup *jobCgroup) *exec.Cmd {
+	ulimits := []string{}
+	if limits.CPUSeconds > 0 {
+		ulimits = append(ulimits, fmt.Sprintf("-t %d", limits.CPUSeconds))
+	}
+	if limits.OpenFiles > 0 {
+		ulimits = append(ulimits, fmt.Sprintf("-n %d", limits.OpenFiles))
+	}
+	if cgroup == nil && limits.MemoryBytes > 0 {
+		ulimits = append(ulimits, fmt.Sprintf("-v %d", (limits.MemoryBytes+1023)/1024))
+	}
+	if cgroup == nil && limits.Processes > 0 {
+		ulimits = append(ulimits, fmt.Sprintf("-u %d", limits.Processes))
+	}
+	wrapper := ""
+	if len(ulimits) > 0 {
+		wrapper = fmt.Sprintf("ulimit %s || exit 126; ", strings.Join(ulimits, " "))
+	}
+	if cgroup != nil {
+		wrapper += "read -r _ <&3; exec 3<&-; "
+	}
+	wrapper += `exec "$@"`
+
+	arguments := append([]string{"-c", wrapper, "manager-limits", cmd.Path}, cmd.Args[1:]...)
+	limited := exec.Command("bash", arguments...)
+	limited.Env = cmd.Env
+	limited.Dir = cmd.Dir
+	limited.SysProcAttr = cmd.SysProcAttr
+	return limited
+}
+
+// cpuLimitExceeded tells whether a command which exited was killed for exceeding its CPU time
+func cpuLimitExceeded(limits *ResourceLimits, state *os.ProcessState) bool {
+	if limits.CPUSeconds == 0 || state == nil {
+		return false
+	}
+	waitStatus, ok := state.Sys().(syscall.WaitStatus)
+	if !ok || !waitStatus.Signaled() {
+		return false
+	}
+	if waitStatus.Signal() == syscall.SIGXCPU {
+		return true
+	}
+	cpuTime := state.UserTime() + state.SystemTime()
+	return waitStatus.Signal() == syscall.SIGKILL && cpuTime >= time.Duration(limits.CPUSeconds)*time.Second
+}
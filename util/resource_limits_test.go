@@ -0,0 +1,63 @@
+package util
+
+import (
+	"context"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLimitedCmd(t *testing.T) {
+	limits := &ResourceLimits{CPUSeconds: 5, OpenFiles: 64, MemoryBytes: 1024*1024 + 1, Processes: 10}
+	cmd := exec.Command("/bin/echo", "a", "b c")
+	cmd.Env = []string{"A=1"}
+	cmd.Dir = "/tmp"
+
+	t.Run("without cgroup", func(t *testing.T) {
+		limited := limitedCmd(cmd, limits, nil)
+		expected := []string{"bash", "-c", `ulimit -t 5 -n 64 -v 1025 -u 10 || exit 126; exec "$@"`, "manager-limits", "/bin/echo", "a", "b c"}
+		if !reflect.DeepEqual(limited.Args, expected) {
+			t.Errorf("expected %q, got %q", expected, limited.Args)
+		}
+		if !reflect.DeepEqual(limited.Env, cmd.Env) || limited.Dir != cmd.Dir {
+			t.Errorf("expected environment and directory of the wrapped command, got %q in %s", limited.Env, limited.Dir)
+		}
+	})
+	t.Run("with cgroup", func(t *testing.T) {
+		// The cgroup enforces memory and processes; the wrapper waits to be moved into it
+		limited := limitedCmd(cmd, limits, &jobCgroup{path: "/nonexistent"})
+		expected := `ulimit -t 5 -n 64 || exit 126; read -r _ <&3; exec 3<&-; exec "$@"`
+		if limited.Args[2] != expected {
+			t.Errorf("expected wrapper %q, got %q", expected, limited.Args[2])
+		}
+	})
+	t.Run("no rlimits", func(t *testing.T) {
+		limited := limitedCmd(cmd, &ResourceLimits{Processes: 10}, &jobCgroup{path: "/nonexistent"})
+		if strings.Contains(limited.Args[2], "ulimit") {
+			t.Errorf("expected no ulimit call, got %q", limited.Args[2])
+		}
+	})
+}
+
+func TestLimitedCmdAppliesRlimits(t *testing.T) {
+	limited := limitedCmd(exec.Command("sh", "-c", `ulimit -n; echo "$1"`, "sh", "arg"), &ResourceLimits{OpenFiles: 64}, nil)
+	output, err := limited.Output()
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if string(output) != "64\narg\n" {
+		t.Errorf("expected the open files rlimit and the argument, got %q", output)
+	}
+}
+
+func TestRunCommandReportsTruncatedOutput(t *testing.T) {
+	spec := &CommandSpec{Argv: []string{"printf", "0123456789"}, CaptureOutput: true, MaxOutputBytes: 4}
+	result, err := RunCommand(context.Background(), spec)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if result.Stdout != "0123" || !result.StdoutTruncated || result.LimitExceeded != LimitOutput {
+		t.Errorf("expected stdout truncated to 4 bytes and the output limit reported, got %+v", result)
+	}
+}